@@ -1,8 +1,11 @@
 module github.com/ecromaneli-golang/http
 
-go 1.18
+go 1.22
 
-require github.com/stretchr/testify v1.7.1
+require (
+	github.com/stretchr/testify v1.7.1
+	golang.org/x/sys v0.30.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
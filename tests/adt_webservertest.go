@@ -4,23 +4,23 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 
 	"github.com/ecromaneli-golang/http/webserver"
 )
 
-var port = 8500
-
 type WebServerTest struct {
-	ServerHost    string
-	ServerPort    int
 	ServerMethod  string
 	ServerPattern string
 	ServerHandler webserver.Handler
+	ServerSetup   func(server *webserver.Server)
+	ServerFS      http.FileSystem
 
 	RequestMethod      string
 	RequestContentType string
+	RequestHeaders     map[string]string
 	RequestPath        string
 	RequestHost        string
 	RequestPort        int
@@ -28,11 +28,6 @@ type WebServerTest struct {
 }
 
 func (this *WebServerTest) SetDefaults() {
-	if this.ServerPort == 0 {
-		port++
-		this.ServerPort = port
-	}
-
 	if this.ServerMethod == "" {
 		this.ServerMethod = http.MethodGet
 	}
@@ -49,10 +44,6 @@ func (this *WebServerTest) SetDefaults() {
 		this.RequestHost = "localhost"
 	}
 
-	if this.RequestPort == 0 {
-		this.RequestPort = this.ServerPort
-	}
-
 	if this.RequestMethod == "" {
 		this.RequestMethod = http.MethodGet
 	}
@@ -67,29 +58,38 @@ func (this WebServerTest) Do() error {
 	return err
 }
 
+// DoAndGetDetails returns the response with the body already read, since the server is stopped before returning
 func (this WebServerTest) DoAndGetDetails() (req *http.Request, res *http.Response, err error) {
 
 	// Given
 	this.SetDefaults()
 
-	server := webserver.NewServer()
+	server := webserver.NewServerWithFS(this.ServerFS)
 	server.Handle(this.ServerMethod, this.ServerPattern, this.ServerHandler)
 
+	if this.ServerSetup != nil {
+		this.ServerSetup(server)
+	}
+
 	// When
-	go func() {
-		panic(server.ListenAndServe(this.ServerHost + ":" + strconv.Itoa(this.ServerPort)))
-	}()
+	addr, stop, err := server.ListenAndServeReady()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer stop()
+
+	if this.RequestPort == 0 {
+		_, port, _ := net.SplitHostPort(addr)
+		this.RequestPort, _ = strconv.Atoi(port)
+	}
 
 	var body io.Reader
 	if this.RequestBody != nil {
 		body = bytes.NewBuffer(this.RequestBody)
 	}
 
-	// param := make(map[string][]string)
-	// param["testBody"] = []string{"valueBody"}
-
-	// res, err = http.PostForm("http://localhost:"+strconv.Itoa(this.ServerPort)+this.RequestPath, param)
-
 	req, err = http.NewRequest(this.RequestMethod, "http://"+this.RequestHost+":"+strconv.Itoa(this.RequestPort)+this.RequestPath, body)
 
 	if err != nil {
@@ -100,12 +100,24 @@ func (this WebServerTest) DoAndGetDetails() (req *http.Request, res *http.Respon
 		req.Header.Add(webserver.ContentTypeHeader, this.RequestContentType)
 	}
 
+	for name, value := range this.RequestHeaders {
+		req.Header.Add(name, value)
+	}
+
 	res, err = http.DefaultClient.Do(req)
 
 	if err != nil {
 		return req, nil, err
 	}
 
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(data))
+
+	if err != nil {
+		return req, res, err
+	}
+
 	if res.StatusCode != http.StatusOK {
 		return req, res, errors.New(res.Status)
 	}
@@ -113,4 +125,20 @@ func (this WebServerTest) DoAndGetDetails() (req *http.Request, res *http.Respon
 	return req, res, nil
 }
 
+func (this WebServerTest) DoAndReadBody() (res *http.Response, body string, err error) {
+	_, res, err = this.DoAndGetDetails()
+
+	if res == nil {
+		return res, "", err
+	}
+
+	data, readErr := io.ReadAll(res.Body)
+
+	if err == nil {
+		err = readErr
+	}
+
+	return res, string(data), err
+}
+
 func emptyHandler(req *webserver.Request, res *webserver.Response) {}
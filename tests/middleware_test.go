@@ -0,0 +1,502 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ecromaneli-golang/http/webserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldAcceptConsumedContentType(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		ServerHandler:      webserver.Consumes(webserver.ContentTypeJson)(emptyHandler),
+		RequestMethod:      http.MethodPost,
+		RequestContentType: "application/json; charset=utf-8",
+		RequestBody:        []byte("{}"),
+	}
+
+	// Then
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldRejectNotConsumedContentType(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		ServerHandler:      webserver.Consumes(webserver.ContentTypeJson)(emptyHandler),
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestBody:        []byte("a=b"),
+	}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusUnsupportedMediaType))
+}
+
+func TestShouldNotEnforceConsumedContentTypeWithoutBody(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: webserver.Consumes(webserver.ContentTypeJson)(emptyHandler)}
+
+	// Then
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldEnforceConsumedContentTypeByBodyNotMethod(t *testing.T) {
+	// When
+	emptyPost := WebServerTest{
+		ServerMethod:  http.MethodPost,
+		ServerHandler: webserver.Consumes(webserver.ContentTypeJson)(emptyHandler),
+		RequestMethod: http.MethodPost,
+	}
+	deleteWithBody := WebServerTest{
+		ServerMethod:       http.MethodDelete,
+		ServerHandler:      webserver.Consumes(webserver.ContentTypeJson)(emptyHandler),
+		RequestMethod:      http.MethodDelete,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestBody:        []byte("a=b"),
+	}
+
+	// Then
+	panicIfNotNil(emptyPost.Do())
+	assert.ErrorContains(t, deleteWithBody.Do(), http.StatusText(http.StatusUnsupportedMediaType))
+}
+
+func TestShouldCheckConsumedContentTypeWithoutReadingBody(t *testing.T) {
+	// Given
+	body, contentType := newMultipartBody(nil, map[string]string{"upload": "file content"})
+	var tracked *trackedBody
+	var readBeforeHandler bool
+	var parts []string
+
+	stream := webserver.NoBodyParse(func(req *webserver.Request, res *webserver.Response) {
+		readBeforeHandler = tracked.read
+
+		panicIfNotNil(req.EachPart(func(part *multipart.Part) error {
+			data, err := io.ReadAll(part)
+			parts = append(parts, part.FormName()+"="+string(data))
+			return err
+		}))
+	})
+
+	// When
+	test := WebServerTest{
+		ServerMethod: http.MethodPost,
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			tracked = &trackedBody{Reader: req.Raw.Body}
+			req.Raw.Body = io.NopCloser(tracked)
+			webserver.Consumes(webserver.ContentTypeFormData)(stream)(req, res)
+		},
+		RequestMethod:      http.MethodPost,
+		RequestContentType: contentType,
+		RequestBody:        body,
+	}
+
+	// Then
+	panicIfNotNil(test.Do())
+	assert.False(t, readBeforeHandler)
+	assert.Equal(t, []string{"upload=file content"}, parts)
+}
+
+func TestShouldNotParseBodyWhenDisabled(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestPath:        "/?query=value",
+		RequestBody:        []byte("param=value"),
+	}
+
+	// Then
+	test.ServerHandler = webserver.NoBodyParse(func(req *webserver.Request, res *webserver.Response) {
+		body := &trackedBody{Reader: req.Raw.Body}
+		req.Raw.Body = body
+
+		assert.Equal(t, "value", req.Param("query"))
+		assert.Equal(t, "", req.Param("param"))
+		assert.False(t, body.read)
+
+		data, err := io.ReadAll(req.BodyReader())
+		assert.NoError(t, err)
+		assert.Equal(t, "param=value", string(data))
+	})
+
+	test2 := test
+	test2.ServerHandler = webserver.NoBodyParse(func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "", req.Param("param"))
+		assert.Equal(t, "param=value", string(req.Body()))
+	})
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldSendContinueWhenExpectationIsAccepted(t *testing.T) {
+	// Given
+	var body string
+	var continued bool
+
+	server := webserver.NewServer().Post("/upload", webserver.ExpectContinue(func(req *webserver.Request) bool {
+		return req.Header("Content-Length") == "5"
+	})(func(req *webserver.Request, res *webserver.Response) {
+		body = string(req.Body())
+	}))
+
+	addr, stop, err := server.ListenAndServeReady()
+	panicIfNotNil(err)
+	defer stop()
+
+	trace := &httptrace.ClientTrace{Got100Continue: func() { continued = true }}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/upload", bytes.NewBufferString("hello"))
+	panicIfNotNil(err)
+	req.Header.Set("Expect", "100-continue")
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	// When
+	res, err := http.DefaultClient.Do(req)
+	panicIfNotNil(err)
+	res.Body.Close()
+
+	// Then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, continued)
+	assert.Equal(t, "hello", body)
+}
+
+func TestShouldRejectExpectationBeforeReadingBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod: http.MethodPost,
+		ServerHandler: webserver.ExpectContinue(func(req *webserver.Request) bool { return false })(func(req *webserver.Request, res *webserver.Response) {
+			t.Error("the handler must not run")
+		}),
+		RequestMethod:  http.MethodPost,
+		RequestHeaders: map[string]string{"Expect": "100-continue"},
+		RequestBody:    []byte("too large"),
+	}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusExpectationFailed))
+}
+
+func TestShouldIgnoreExpectContinuePolicyWithoutHeader(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:  http.MethodPost,
+		ServerHandler: webserver.ExpectContinue(func(req *webserver.Request) bool { return false })(emptyHandler),
+		RequestMethod: http.MethodPost,
+		RequestBody:   []byte("body"),
+	}
+
+	// Then
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldPostProcessBufferedResponse(t *testing.T) {
+	// Given
+	footer := func(next webserver.Handler) webserver.Handler {
+		return func(req *webserver.Request, res *webserver.Response) {
+			res.Buffer()
+			next(req, res)
+			panicIfNotNil(res.Replace(append(res.Bytes(), "<footer>nonce</footer>"...)))
+		}
+	}
+
+	test := WebServerTest{
+		ServerHandler: footer(func(req *webserver.Request, res *webserver.Response) {
+			res.Header(webserver.ContentTypeHeader, "text/html").Write([]byte("<main>page</main>"))
+		}),
+	}
+
+	// When
+	res, body, err := test.DoAndReadBody()
+	panicIfNotNil(err)
+
+	// Then
+	assert.Equal(t, "<main>page</main><footer>nonce</footer>", body)
+	assert.Equal(t, "text/html", res.Header.Get(webserver.ContentTypeHeader))
+}
+
+func TestShouldNotReplaceUnbufferedResponse(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			assert.Nil(t, res.Bytes())
+			assert.Error(t, res.Replace([]byte("other")))
+			res.WriteText("original")
+		},
+	}
+
+	_, body, err := test.DoAndReadBody()
+	panicIfNotNil(err)
+
+	// Then
+	assert.Equal(t, "original", body)
+}
+
+func TestShouldCompressOnlyTheRouteOptingIn(t *testing.T) {
+	// Given
+	payload := strings.Repeat("compressible ", 100)
+	write := func(req *webserver.Request, res *webserver.Response) { res.WriteText(payload) }
+
+	server := webserver.NewServer().
+		Get("/large", webserver.Compress(write)).
+		Get("/small", write).
+		Get("/dynamic", func(req *webserver.Request, res *webserver.Response) {
+			if req.Param("size") == "large" {
+				res.EnableGzip()
+			}
+			write(req, res)
+		})
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// When
+	large, small, dynamic := request("/large"), request("/small"), request("/dynamic?size=large")
+
+	// Then
+	assert.Equal(t, "gzip", large.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", large.Header().Get("Vary"))
+	assert.Less(t, large.Body.Len(), len(payload))
+	assert.Equal(t, payload, gunzip(large.Body.Bytes()))
+
+	assert.Empty(t, small.Header().Get("Content-Encoding"))
+	assert.Equal(t, payload, small.Body.String())
+
+	assert.Equal(t, "gzip", dynamic.Header().Get("Content-Encoding"))
+	assert.Equal(t, payload, gunzip(dynamic.Body.Bytes()))
+}
+
+func TestShouldDetectContentTypeOfCompressedWrite(t *testing.T) {
+	// Given
+	payload := "<html><body>" + strings.Repeat("compressible ", 100) + "</body></html>"
+
+	server := webserver.NewServer().Get("/", webserver.Compress(func(req *webserver.Request, res *webserver.Response) {
+		res.Write([]byte(payload))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(recorder, req)
+
+	// Then
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, payload, gunzip(recorder.Body.Bytes()))
+}
+
+func TestShouldNotCompressWhenClientDoesNotAcceptGzip(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Get("/", webserver.Compress(func(req *webserver.Request, res *webserver.Response) {
+		res.WriteText("plain")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, br")
+	recorder := httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(recorder, req)
+
+	// Then
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+	assert.Equal(t, "plain", recorder.Body.String())
+}
+
+func TestShouldFlushCompressedStream(t *testing.T) {
+	// Given
+	read := make(chan bool)
+
+	server := webserver.NewServer().Get("/", webserver.Compress(func(req *webserver.Request, res *webserver.Response) {
+		panicIfNotNil(res.FlushText("first\n"))
+		<-read
+		panicIfNotNil(res.FlushText("second\n"))
+	}))
+
+	addr, stop, err := server.ListenAndServeReady()
+	panicIfNotNil(err)
+	defer stop()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr, nil)
+	panicIfNotNil(err)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// The transport must not decompress by itself
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	// When
+	res, err := client.Do(req)
+	panicIfNotNil(err)
+	defer res.Body.Close()
+
+	reader, err := gzip.NewReader(res.Body)
+	panicIfNotNil(err)
+
+	first := make([]byte, len("first\n"))
+	_, err = io.ReadFull(reader, first)
+	panicIfNotNil(err)
+	close(read)
+
+	rest, err := io.ReadAll(reader)
+	panicIfNotNil(err)
+
+	// Then
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "first\n", string(first))
+	assert.Equal(t, "second\n", string(rest))
+}
+
+func gunzip(data []byte) string {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	panicIfNotNil(err)
+
+	result, err := io.ReadAll(reader)
+	panicIfNotNil(err)
+
+	return string(result)
+}
+
+func TestShouldAnswerGatewayTimeoutForSlowHandler(t *testing.T) {
+	// Given
+	slow := func(req *webserver.Request, res *webserver.Response) {
+		<-req.Context().Done()
+		res.WriteText("too late")
+	}
+
+	server := webserver.NewServer().SetLogOutput(io.Discard).
+		Get("/slow", webserver.Timeout(20*time.Millisecond, "Upstream took too long")(slow)).
+		Get("/fast", webserver.Timeout(time.Second, "Upstream took too long")(func(req *webserver.Request, res *webserver.Response) {
+			res.Status(http.StatusAccepted).WriteText("fast")
+		}))
+
+	slowRecorder, fastRecorder := httptest.NewRecorder(), httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(slowRecorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	server.TestHandler().ServeHTTP(fastRecorder, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	// Then
+	assert.Equal(t, http.StatusGatewayTimeout, slowRecorder.Code)
+	assert.Equal(t, "Upstream took too long", slowRecorder.Body.String())
+	assert.Equal(t, http.StatusAccepted, fastRecorder.Code)
+	assert.Equal(t, "fast", fastRecorder.Body.String())
+}
+
+func TestShouldRefillRateLimitBucket(t *testing.T) {
+	// Given
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	server := webserver.NewServer().SetLogOutput(io.Discard).
+		Get("/", webserver.RateLimitWithClock(2, time.Second, nil, clock)(emptyHandler))
+
+	request := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder
+	}
+
+	// When
+	first, second, third := request(), request(), request()
+
+	now = now.Add(499 * time.Millisecond)
+	beforeRefill := request()
+
+	now = now.Add(2 * time.Millisecond)
+	afterRefill := request()
+
+	// Then
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, http.StatusTooManyRequests, third.Code)
+	assert.Equal(t, "1", third.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, beforeRefill.Code)
+	assert.Equal(t, http.StatusOK, afterRefill.Code)
+}
+
+func TestShouldRateLimitEachRouteIndependently(t *testing.T) {
+	// Given
+	byRoute := webserver.RateLimit(1, time.Minute, webserver.KeyByIPAndRoute)
+	byIP := webserver.RateLimit(1, time.Minute, nil)
+
+	server := webserver.NewServer().SetLogOutput(io.Discard).
+		Get("/route/a", byRoute(emptyHandler)).
+		Get("/route/{id}", byRoute(emptyHandler)).
+		Get("/ip/a", byIP(emptyHandler)).
+		Get("/ip/b", byIP(emptyHandler))
+
+	request := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// When
+	first, repeated := request("/route/a", "192.0.2.1:1"), request("/route/a", "192.0.2.1:2")
+	otherRoute, samePatternOtherPath := request("/route/1", "192.0.2.1:3"), request("/route/2", "192.0.2.1:4")
+	otherClient := request("/route/a", "192.0.2.2:1")
+	sharedFirst, sharedSecond := request("/ip/a", "192.0.2.1:5"), request("/ip/b", "192.0.2.1:6")
+
+	// Then
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusTooManyRequests, repeated.Code)
+	assert.Equal(t, "60", repeated.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, otherRoute.Code)
+	assert.Equal(t, http.StatusTooManyRequests, samePatternOtherPath.Code)
+	assert.Equal(t, http.StatusOK, otherClient.Code)
+	assert.Equal(t, http.StatusOK, sharedFirst.Code)
+	assert.Equal(t, http.StatusTooManyRequests, sharedSecond.Code)
+}
+
+func TestShouldKeepRetryAfterOnBufferedRateLimitedResponse(t *testing.T) {
+	// Given
+	buffer := func(next webserver.Handler) webserver.Handler {
+		return func(req *webserver.Request, res *webserver.Response) {
+			res.Buffer()
+			next(req, res)
+		}
+	}
+
+	server := webserver.NewServer().SetLogOutput(io.Discard).
+		Get("/", buffer(webserver.RateLimit(1, time.Minute, nil)(emptyHandler)))
+
+	request := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder
+	}
+
+	// When
+	first, second := request(), request()
+
+	// Then
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusTooManyRequests, second.Code)
+	assert.Equal(t, "60", second.Header().Get("Retry-After"))
+}
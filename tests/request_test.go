@@ -0,0 +1,1155 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ecromaneli-golang/http/webserver"
+	"github.com/stretchr/testify/assert"
+)
+
+type bindTarget struct {
+	Name string `json:"name" form:"name"`
+	Age  int    `json:"age" form:"age"`
+}
+
+func TestShouldBindJSONBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: "application/json; charset=utf-8",
+		RequestBody:        []byte(`{"name":"john","age":30}`),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target bindTarget
+		assert.NoError(t, req.Bind(&target))
+		assert.Equal(t, bindTarget{Name: "john", Age: 30}, target)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldBindFormBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestBody:        []byte("name=john&age=30"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target bindTarget
+		assert.NoError(t, req.Bind(&target))
+		assert.Equal(t, bindTarget{Name: "john", Age: 30}, target)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldBindBodyByContentType(t *testing.T) {
+	// Given
+	multipartBody, multipartContentType := newMultipartBody(map[string]string{"name": "john", "age": "30"}, nil)
+
+	matrix := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/json; charset=utf-8", []byte(`{"name":"john","age":30}`)},
+		{"application/merge-patch+json", []byte(`{"name":"john","age":30}`)},
+		{webserver.ContentTypeFormUrlEncoded, []byte("name=john&age=30")},
+		{multipartContentType, multipartBody},
+	}
+
+	for _, item := range matrix {
+		// When
+		test := WebServerTest{
+			ServerMethod:       http.MethodPost,
+			RequestMethod:      http.MethodPost,
+			RequestContentType: item.contentType,
+			RequestBody:        item.body,
+		}
+
+		// Then
+		test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+			var target bindTarget
+			assert.NoError(t, req.BindBody(&target), item.contentType)
+			assert.Equal(t, bindTarget{Name: "john", Age: 30}, target, item.contentType)
+		}
+
+		panicIfNotNil(test.Do())
+	}
+}
+
+func TestShouldNotBindBodyOfUnsupportedContentType(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: "text/csv",
+		RequestBody:        []byte("john,30"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target bindTarget
+		assert.ErrorContains(t, req.BindBody(&target), "415")
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldBindUsingCustomDecoder(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: "text/csv",
+		RequestBody:        []byte("john,30"),
+	}
+
+	test.ServerSetup = func(server *webserver.Server) {
+		server.RegisterDecoder("text/csv", func(body []byte, v any) error {
+			fields := strings.Split(string(body), ",")
+			v.(*bindTarget).Name = fields[0]
+			return nil
+		})
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target bindTarget
+		assert.NoError(t, req.Bind(&target))
+		assert.Equal(t, "john", target.Name)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldNotBindUnknownContentType(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: "application/unknown",
+		RequestBody:        []byte("data"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target bindTarget
+		assert.ErrorContains(t, req.Bind(&target), "415")
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+type trackedBody struct {
+	io.Reader
+	read bool
+}
+
+func (this *trackedBody) Read(data []byte) (int, error) {
+	this.read = true
+	return this.Reader.Read(data)
+}
+
+func (this *trackedBody) Close() error {
+	return nil
+}
+
+func TestShouldReadQueryWithoutTouchingBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestPath:        "/?page=2&tag=a&tag=b",
+		RequestBody:        []byte("bodyParam=bodyValue"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		body := &trackedBody{Reader: req.Raw.Body}
+		req.Raw.Body = body
+
+		assert.Equal(t, "page=2&tag=a&tag=b", req.RawQuery())
+		assert.Equal(t, "2", req.QueryValues().Get("page"))
+		assert.Equal(t, []string{"a", "b"}, req.QueryValues()["tag"])
+		assert.False(t, body.read)
+
+		assert.Equal(t, "bodyValue", req.Param("bodyParam"))
+		assert.True(t, body.read)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldReadTypedQueryValuesWithDefaults(t *testing.T) {
+	// Given
+	var page, limit, missing int
+	var price float64
+	var active, checked, sort, malformed bool
+	var order string
+
+	server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		page = req.Query("page").Default(1).Int()
+		limit = req.Query("limit").Default(20).Int()
+		missing = req.Query("missing").Int()
+		price = req.Query("price").Float()
+		active = req.Query("active").Bool()
+		checked = req.Query("checked").Bool()
+		sort = req.Query("sort").Bool()
+		malformed = req.Query("page").Bool()
+		order = req.Query("order").Default("asc").String()
+	})
+
+	// When
+	recorder := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?page=abc&price=1.5&active=true&checked=on&sort=", nil))
+
+	// Then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 0, page, "a malformed value is not replaced by the default")
+	assert.Equal(t, 20, limit)
+	assert.Equal(t, 0, missing)
+	assert.Equal(t, 1.5, price)
+	assert.True(t, active)
+	assert.True(t, checked)
+	assert.False(t, sort)
+	assert.False(t, malformed)
+	assert.Equal(t, "asc", order)
+}
+
+func TestShouldNotReadBodyOfGetRequestsWithoutContentType(t *testing.T) {
+	// When
+	test := WebServerTest{RequestPath: "/?param=value"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		body := &trackedBody{Reader: req.Raw.Body}
+		req.Raw.Body = body
+
+		assert.Equal(t, "value", req.Param("param"))
+		assert.False(t, body.read)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldNotReadEmptyBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		body := &trackedBody{Reader: req.Raw.Body}
+		req.Raw.Body = body
+
+		assert.Empty(t, req.AllParams())
+		assert.False(t, body.read)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldKeepFormBodyAfterParsingParams(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestBody:        []byte("param1=value1&param2=value2"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "value1", req.Param("param1"))
+		assert.Equal(t, "value2", req.Raw.PostForm.Get("param2"))
+		assert.Equal(t, "param1=value1&param2=value2", string(req.Body()))
+
+		body, err := io.ReadAll(req.Raw.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "param1=value1&param2=value2", string(body))
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldFillRawFormWithBodyAndQuery(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestPath:        "/?both=query&query=value1",
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestBody:        []byte("both=body&body=value2"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		req.AllParams()
+
+		assert.Equal(t, []string{"body", "query"}, req.Raw.Form["both"])
+		assert.Equal(t, "value1", req.Raw.FormValue("query"))
+		assert.Equal(t, "value2", req.Raw.FormValue("body"))
+		assert.Empty(t, req.Raw.PostForm.Get("query"))
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldRemoveMultipartTempFilesAfterResponse(t *testing.T) {
+	// Given
+	body, contentType := newMultipartBody(map[string]string{"param1": "value1"}, map[string]string{"file1": strings.Repeat("x", 1024*1024)})
+	var tempFile string
+
+	handler := func(req *webserver.Request, res *webserver.Response) {
+		file, err := req.File("file1").Open()
+		panicIfNotNil(err)
+		defer file.Close()
+
+		tempFile = file.(*os.File).Name()
+		assert.Equal(t, []string{"value1"}, req.Raw.Form["param1"])
+	}
+
+	server := webserver.NewServer().SetPathCleaning(true).Post("/upload", handler)
+
+	req := httptest.NewRequest(http.MethodPost, "//upload", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+
+	// When
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), req)
+
+	// Then
+	assert.NotEmpty(t, tempFile)
+	_, err := os.Stat(tempFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestShouldKeepMultipartBodyAfterParsingParams(t *testing.T) {
+	// Given
+	body, contentType := newMultipartBody(map[string]string{"param1": "value1"}, map[string]string{"file1": "content1"})
+
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: contentType,
+		RequestBody:        body,
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "value1", req.Param("param1"))
+		assert.Equal(t, "file1.txt", req.File("file1").Filename)
+		assert.Equal(t, body, req.Body())
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func newMultipartBody(params map[string]string, files map[string]string) (body []byte, contentType string) {
+	buffer := &bytes.Buffer{}
+	writer := multipart.NewWriter(buffer)
+
+	for name, value := range params {
+		panicIfNotNil(writer.WriteField(name, value))
+	}
+
+	for name, content := range files {
+		part, err := writer.CreateFormFile(name, name+".txt")
+		panicIfNotNil(err)
+
+		_, err = part.Write([]byte(content))
+		panicIfNotNil(err)
+	}
+
+	panicIfNotNil(writer.Close())
+	return buffer.Bytes(), writer.FormDataContentType()
+}
+
+func TestShouldPanicOnInvalidTypedParamByDefault(t *testing.T) {
+	// When
+	test := WebServerTest{
+		RequestPath:   "/?id=abc",
+		ServerSetup:   func(server *webserver.Server) { server.SetLogOutput(io.Discard) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) { req.IntParam("id") },
+	}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	assert.ErrorContains(t, err, http.StatusText(http.StatusBadRequest))
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "invalid value 'abc' for 'id', expected int", body)
+}
+
+func TestShouldDescribeInvalidTypedParam(t *testing.T) {
+	// When
+	test := WebServerTest{
+		RequestPath: "/?id=abc",
+		ServerSetup: func(server *webserver.Server) { server.SetParamErrorMode(webserver.ParamErrorZeroValue) },
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		req.IntParam("id")
+
+		var validationErr *webserver.ValidationError
+		assert.ErrorAs(t, req.ParamError(), &validationErr)
+		assert.Equal(t, webserver.ValidationError{Field: "id", Value: "abc", Expected: "int"}, *validationErr)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldDescribeInvalidBoundField(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeJson,
+		RequestBody:        []byte(`{"name":"john","age":"thirty"}`),
+	}
+	test2 := test
+	test2.RequestContentType = webserver.ContentTypeFormUrlEncoded
+	test2.RequestBody = []byte("name=john&age=thirty")
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var validationErr *webserver.ValidationError
+		assert.ErrorAs(t, req.Bind(&bindTarget{}), &validationErr)
+		assert.Equal(t, "age", validationErr.Field)
+		assert.Equal(t, "int", validationErr.Expected)
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		err := req.Bind(&bindTarget{})
+		assert.ErrorContains(t, err, "400")
+		assert.ErrorContains(t, err, "invalid value 'thirty' for 'age', expected int")
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldReturnZeroValueOnInvalidTypedParam(t *testing.T) {
+	// When
+	test := WebServerTest{
+		RequestPath: "/?id=abc&price=1.5&amount=x",
+		ServerSetup: func(server *webserver.Server) {
+			server.SetParamErrorMode(webserver.ParamErrorZeroValue)
+		},
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, float64(1.5), req.Float64Param("price"))
+		assert.NoError(t, req.ParamError())
+
+		assert.Equal(t, 0, req.IntParam("id"))
+		assert.ErrorContains(t, req.ParamError(), "abc")
+
+		assert.Equal(t, float32(0), req.Float32Param("amount"))
+		assert.ErrorContains(t, req.ParamError(), "x")
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldStreamFileToWriter(t *testing.T) {
+	// Given
+	body, contentType := newMultipartBody(nil, map[string]string{"file1": "content1"})
+
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: contentType,
+		RequestBody:        body,
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		dst := &bytes.Buffer{}
+
+		written, err := req.StreamFile("file1", dst)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len("content1")), written)
+		assert.Equal(t, "content1", dst.String())
+
+		_, err = req.StreamFile("missing", dst)
+		assert.ErrorContains(t, err, "missing")
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldProvideCommonHeaderShortcuts(t *testing.T) {
+	// When
+	test := WebServerTest{RequestHeaders: map[string]string{
+		"Referer":    "http://localhost/previous",
+		"User-Agent": "test-agent",
+		"Origin":     "http://localhost",
+	}}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "http://localhost/previous", req.Referer())
+		assert.Equal(t, "test-agent", req.UserAgent())
+		assert.Equal(t, "http://localhost", req.Origin())
+		assert.Equal(t, req.Raw.Host, req.Host())
+		assert.Contains(t, req.Host(), "localhost:")
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldReadOriginWithoutReadingBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestHeaders:     map[string]string{"Origin": "http://localhost"},
+		RequestBody:        []byte("param=value"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		body := &trackedBody{Reader: req.Raw.Body}
+		req.Raw.Body = io.NopCloser(body)
+
+		assert.Equal(t, "http://localhost", req.Origin())
+		assert.False(t, body.read)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldProvideLastEventID(t *testing.T) {
+	// When
+	test := WebServerTest{RequestHeaders: map[string]string{"Last-Event-ID": "42"}}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "42", req.LastEventID())
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldProvideProtocol(t *testing.T) {
+	// When
+	test := WebServerTest{}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "HTTP/1.1", req.Proto())
+		assert.Equal(t, 1, req.ProtoMajor())
+		assert.False(t, req.IsHTTP2())
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldParseRanges(t *testing.T) {
+	// When
+	test := WebServerTest{RequestHeaders: map[string]string{"Range": "bytes=0-9"}}
+	test2 := WebServerTest{RequestHeaders: map[string]string{"Range": "bytes=0-4, 10-, -5"}}
+	test3 := WebServerTest{}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		ranges, err := req.Ranges(100)
+		assert.NoError(t, err)
+		assert.Equal(t, []webserver.Range{{Start: 0, Length: 10}}, ranges)
+		assert.Equal(t, "bytes 0-9/100", ranges[0].ContentRange(100))
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		ranges, err := req.Ranges(100)
+		assert.NoError(t, err)
+		assert.Equal(t, []webserver.Range{{Start: 0, Length: 5}, {Start: 10, Length: 90}, {Start: 95, Length: 5}}, ranges)
+	}
+	test3.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		ranges, err := req.Ranges(100)
+		assert.NoError(t, err)
+		assert.Nil(t, ranges)
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+	panicIfNotNil(test3.Do())
+}
+
+func TestShouldNotSatisfyOutOfBoundsRange(t *testing.T) {
+	// When
+	test := WebServerTest{RequestHeaders: map[string]string{"Range": "bytes=200-300"}}
+	test2 := WebServerTest{RequestHeaders: map[string]string{"Range": "bytes=50-10"}}
+
+	// Then
+	handler := func(req *webserver.Request, res *webserver.Response) {
+		_, err := req.Ranges(100)
+		assert.ErrorContains(t, err, "416")
+	}
+	test.ServerHandler = handler
+	test2.ServerHandler = handler
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldProvideFormValues(t *testing.T) {
+	// Given
+	body, contentType := newMultipartBody(map[string]string{"multipart": "value3"}, nil)
+
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestPath:        "/?query=value1&both=query",
+		RequestBody:        []byte("body=value2&both=body"),
+	}
+	test2 := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: contentType,
+		RequestBody:        body,
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "value1", req.FormValue("query"))
+		assert.Equal(t, "value2", req.FormValue("body"))
+		assert.Equal(t, req.Param("both"), req.FormValue("both"))
+
+		assert.Equal(t, "", req.PostFormValue("query"))
+		assert.Equal(t, "value2", req.PostFormValue("body"))
+		assert.Equal(t, "body", req.PostFormValue("both"))
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "value3", req.PostFormValue("multipart"))
+		assert.Equal(t, "", req.PostFormValue("missing"))
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+type queryTarget struct {
+	Page    int           `query:"page" default:"1"`
+	Size    uint          `query:"size" default:"20"`
+	Price   float64       `query:"price"`
+	Active  bool          `query:"active"`
+	Since   time.Time     `query:"since"`
+	Timeout time.Duration `query:"timeout"`
+	Tags    []string      `query:"tag"`
+}
+
+func TestShouldBindQuery(t *testing.T) {
+	// When
+	test := WebServerTest{RequestPath: "/?page=3&price=9.5&active=true&since=2024-01-02T03:04:05Z&timeout=1m30s&tag=a&tag=b"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target queryTarget
+		assert.NoError(t, req.BindQuery(&target))
+		assert.Equal(t, queryTarget{
+			Page:    3,
+			Size:    20,
+			Price:   9.5,
+			Active:  true,
+			Since:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Timeout: 90 * time.Second,
+			Tags:    []string{"a", "b"},
+		}, target)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldNotBindInvalidQuery(t *testing.T) {
+	// When
+	test := WebServerTest{RequestPath: "/?since=yesterday"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		err := req.BindQuery(&queryTarget{})
+		assert.ErrorContains(t, err, "400")
+		assert.ErrorContains(t, err, "invalid value 'yesterday' for 'since', expected time.Time")
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldBindPath(t *testing.T) {
+	// When
+	test := WebServerTest{ServerPattern: "/{id}/{slug}", RequestPath: "/12/hello-world?id=99"}
+	test2 := WebServerTest{ServerPattern: "/{id}/{slug}", RequestPath: "/abc/hello-world"}
+
+	// Then
+	type pathTarget struct {
+		ID   int    `path:"id"`
+		Slug string `path:"slug"`
+	}
+
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target pathTarget
+		assert.NoError(t, req.BindPath(&target))
+		assert.Equal(t, pathTarget{ID: 12, Slug: "hello-world"}, target)
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.ErrorContains(t, req.BindPath(&pathTarget{}), "invalid value 'abc' for 'id', expected int")
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+type validatedTarget struct {
+	Name string `json:"name" query:"name" validate:"required"`
+	Age  int    `json:"age" query:"age" validate:"min=1,max=100"`
+	Role string `json:"role" query:"role" validate:"oneof=admin user"`
+}
+
+func TestShouldReportEveryValidationFailure(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeJson,
+		RequestBody:        []byte(`{"age":150,"role":"guest"}`),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		err := req.Bind(&validatedTarget{})
+
+		var validationErrs webserver.ValidationErrors
+		assert.ErrorAs(t, err, &validationErrs)
+		assert.Equal(t, webserver.ValidationErrors{
+			{Field: "name", Value: "", Expected: "required"},
+			{Field: "age", Value: "150", Expected: "max=100"},
+			{Field: "role", Value: "guest", Expected: "oneof=admin user"},
+		}, validationErrs)
+
+		var validationErr *webserver.ValidationError
+		assert.ErrorAs(t, err, &validationErr)
+		assert.Equal(t, "name", validationErr.Field)
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldValidateBoundQuery(t *testing.T) {
+	// When
+	test := WebServerTest{RequestPath: "/?name=john&age=0&role=admin"}
+	test2 := WebServerTest{RequestPath: "/?name=john&age=30&role=user"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		err := req.BindQuery(&validatedTarget{})
+		assert.ErrorContains(t, err, "400")
+		assert.ErrorContains(t, err, "invalid value '0' for 'age', expected min=1")
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		var target validatedTarget
+		assert.NoError(t, req.BindQuery(&target))
+		assert.Equal(t, validatedTarget{Name: "john", Age: 30, Role: "user"}, target)
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldReturnSortedParamKeys(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		ServerPattern:      "/{id}",
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestPath:        "/1?zeta=1&alpha=2",
+		RequestBody:        []byte("mid=3"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, []string{"alpha", "id", "mid", "zeta"}, req.SortedParamKeys())
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldRecoverPanicInRequestGoroutine(t *testing.T) {
+	// Given
+	reader, writer := io.Pipe()
+	defer reader.Close()
+
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) { server.SetLogOutput(writer) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			req.Go(func() { panic("background failure") })
+			res.WriteText("ok")
+		},
+	}
+
+	_, body, err := test.DoAndReadBody()
+	panicIfNotNil(err)
+
+	line := make([]byte, 1024)
+	n, _ := reader.Read(line)
+
+	// Then
+	assert.Equal(t, "ok", body)
+	assert.Contains(t, string(line[:n]), "background failure")
+	assert.Contains(t, string(line[:n]), "path=/")
+}
+
+func TestShouldLogWithRequestFields(t *testing.T) {
+	// Given
+	output := &bytes.Buffer{}
+	var ids []string
+
+	server := webserver.NewServer().SetLogOutput(output).SetLogFormat(webserver.LogFormatJSON).
+		Get("/users", func(req *webserver.Request, res *webserver.Response) {
+			ids = append(ids, req.ID())
+			req.Log().Info("listing users")
+		})
+
+	// When
+	tagged := httptest.NewRequest(http.MethodGet, "/users", nil)
+	tagged.Header.Set("X-Request-Id", "abc-123")
+	tagged.RemoteAddr = "10.0.0.1:1234"
+
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), tagged)
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	// Then
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var entry map[string]any
+	panicIfNotNil(json.Unmarshal([]byte(lines[0]), &entry))
+
+	assert.Equal(t, "listing users", entry["message"])
+	assert.Equal(t, "abc-123", entry["request_id"])
+	assert.Equal(t, http.MethodGet, entry["method"])
+	assert.Equal(t, "/users", entry["path"])
+	assert.Equal(t, "10.0.0.1", entry["ip"])
+
+	assert.Equal(t, "abc-123", ids[0])
+	assert.Len(t, ids[1], 16)
+	assert.Contains(t, lines[1], `"request_id":"`+ids[1]+`"`)
+}
+
+func TestShouldDetectWebSocketRequest(t *testing.T) {
+	// Given
+	var detected []bool
+
+	server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		detected = append(detected, req.IsWebSocket())
+	})
+
+	upgrade := httptest.NewRequest(http.MethodGet, "/", nil)
+	upgrade.Header.Set("Connection", "keep-alive, Upgrade")
+	upgrade.Header.Set("Upgrade", "WebSocket")
+
+	upgradeOnly := httptest.NewRequest(http.MethodGet, "/", nil)
+	upgradeOnly.Header.Set("Upgrade", "websocket")
+
+	// When
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), upgrade)
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), upgradeOnly)
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Then
+	assert.Equal(t, []bool{true, false, false}, detected)
+}
+
+func TestShouldDetectSSERequest(t *testing.T) {
+	// Given
+	var detected []bool
+
+	server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		detected = append(detected, req.WantsSSE())
+	})
+
+	stream := httptest.NewRequest(http.MethodGet, "/", nil)
+	stream.Header.Set("Accept", "application/json;q=0.5, text/event-stream")
+
+	html := httptest.NewRequest(http.MethodGet, "/", nil)
+	html.Header.Set("Accept", "text/html, */*")
+
+	// When
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), stream)
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), html)
+
+	// Then
+	assert.Equal(t, []bool{true, false}, detected)
+}
+
+func TestShouldTeeBodyAndKeepItReadable(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:  http.MethodPost,
+		RequestMethod: http.MethodPost,
+		RequestBody:   []byte(`{"event":"push"}`),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		before, after := &bytes.Buffer{}, &bytes.Buffer{}
+
+		panicIfNotNil(req.TeeBody(before))
+		body := string(req.Body())
+		panicIfNotNil(req.TeeBody(after))
+
+		raw, err := io.ReadAll(req.Raw.Body)
+		panicIfNotNil(err)
+
+		assert.Equal(t, `{"event":"push"}`, before.String())
+		assert.Equal(t, `{"event":"push"}`, body)
+		assert.Equal(t, `{"event":"push"}`, after.String())
+		assert.Equal(t, `{"event":"push"}`, string(raw))
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldProvideMethodAndPath(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:  http.MethodPut,
+		ServerPattern: "/files/{name}",
+		RequestMethod: http.MethodPut,
+		RequestPath:   "/files/a%2Fb%20c?x=1",
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, http.MethodPut, req.Method())
+		assert.Equal(t, "/files/a/b c", req.Path())
+		assert.Equal(t, "/files/a%2Fb%20c", req.RawPath())
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldStreamMultipartParts(t *testing.T) {
+	// Given
+	body, contentType := newMultipartBody(nil, map[string]string{"first": "content1", "second": "content2"})
+
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: contentType,
+		RequestBody:        body,
+	}
+
+	// Then
+	test.ServerHandler = webserver.NoBodyParse(func(req *webserver.Request, res *webserver.Response) {
+		parts := make(map[string]string)
+
+		panicIfNotNil(req.EachPart(func(part *multipart.Part) error {
+			data, err := io.ReadAll(part)
+			parts[part.FileName()] = string(data)
+			return err
+		}))
+
+		assert.Equal(t, map[string]string{"first.txt": "content1", "second.txt": "content2"}, parts)
+	})
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldRejectStreamingPartsOfNonMultipartBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeJson,
+		RequestBody:        []byte("{}"),
+		ServerSetup:        func(server *webserver.Server) { server.SetLogOutput(io.Discard) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			panicIfNotNil(req.EachPart(func(part *multipart.Part) error { return nil }))
+		},
+	}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusBadRequest))
+}
+
+func TestShouldParseETagPreconditions(t *testing.T) {
+	// Given
+	var ifMatch, ifNoneMatch, empty []string
+
+	server := webserver.NewServer().Put("/doc", func(req *webserver.Request, res *webserver.Response) {
+		ifMatch, ifNoneMatch = req.IfMatch(), req.IfNoneMatch()
+	}).Get("/doc", func(req *webserver.Request, res *webserver.Response) {
+		empty = req.IfMatch()
+	})
+
+	req := httptest.NewRequest(http.MethodPut, "/doc", nil)
+	req.Header.Add("If-Match", `"v1", W/"v2"`)
+	req.Header.Add("If-Match", `"with,comma"`)
+	req.Header.Set("If-None-Match", `*`)
+
+	// When
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), req)
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/doc", nil))
+
+	// Then
+	assert.Equal(t, []string{`"v1"`, `W/"v2"`, `"with,comma"`}, ifMatch)
+	assert.Equal(t, []string{"*"}, ifNoneMatch)
+	assert.Empty(t, empty)
+}
+
+func TestShouldRejectStaleWriteWithPreconditionFailed(t *testing.T) {
+	// Given
+	current := `"v2"`
+
+	server := webserver.NewServer().Put("/doc", func(req *webserver.Request, res *webserver.Response) {
+		if !slices.Contains(req.IfMatch(), current) {
+			res.End(http.StatusPreconditionFailed)
+			return
+		}
+
+		res.End(http.StatusNoContent)
+	})
+
+	stale, fresh := httptest.NewRequest(http.MethodPut, "/doc", nil), httptest.NewRequest(http.MethodPut, "/doc", nil)
+	stale.Header.Set("If-Match", `"v1"`)
+	fresh.Header.Set("If-Match", `"v1", "v2"`)
+
+	staleRecorder, freshRecorder := httptest.NewRecorder(), httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(staleRecorder, stale)
+	server.TestHandler().ServeHTTP(freshRecorder, fresh)
+
+	// Then
+	assert.Equal(t, http.StatusPreconditionFailed, staleRecorder.Code)
+	assert.Equal(t, http.StatusNoContent, freshRecorder.Code)
+}
+
+func TestShouldGroupParamsBySourceWhenDebugging(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		ServerPattern:      "/users/{id}",
+		ServerSetup:        func(server *webserver.Server) { server.SetDebugParams(true) },
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestPath:        "/users/1?id=2&page=3",
+		RequestBody:        []byte("id=4&name=john"),
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, map[string]map[string][]string{
+			"path":  {"id": {"1"}},
+			"query": {"id": {"2"}, "page": {"3"}},
+			"form":  {"id": {"4"}, "name": {"john"}},
+		}, req.DebugParams())
+		assert.Equal(t, []string{"1", "2", "4"}, req.Params("id"))
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldNotGroupParamsBySourceByDefault(t *testing.T) {
+	// When
+	test := WebServerTest{ServerPattern: "/users/{id}", RequestPath: "/users/1?page=3"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Nil(t, req.DebugParams())
+		assert.Equal(t, "3", req.Param("page"))
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+func TestShouldMergeParamsByPrecedence(t *testing.T) {
+	// Given
+	matrix := []struct {
+		precedence []webserver.ParamSource
+		params     []string
+	}{
+		{nil, []string{"1", "2", "4"}},
+		{[]webserver.ParamSource{webserver.ParamSourceBody, webserver.ParamSourceQuery, webserver.ParamSourcePath}, []string{"4", "2", "1"}},
+		{[]webserver.ParamSource{webserver.ParamSourceQuery}, []string{"2", "1", "4"}},
+	}
+
+	for _, item := range matrix {
+		// When
+		test := WebServerTest{
+			ServerMethod:       http.MethodPost,
+			ServerPattern:      "/users/{id}",
+			RequestMethod:      http.MethodPost,
+			RequestContentType: webserver.ContentTypeFormUrlEncoded,
+			RequestPath:        "/users/1?id=2",
+			RequestBody:        []byte("id=4"),
+		}
+
+		if item.precedence != nil {
+			test.ServerSetup = func(server *webserver.Server) { server.SetParamPrecedence(item.precedence...) }
+		}
+
+		// Then
+		test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+			assert.Equal(t, item.params[0], req.Param("id"))
+			assert.Equal(t, item.params, req.Params("id"))
+			assert.Equal(t, "4", req.PostFormValue("id"))
+			assert.Equal(t, "2", req.QueryValues().Get("id"))
+		}
+
+		panicIfNotNil(test.Do())
+	}
+}
+
+func TestShouldPanicOnUnknownParamSource(t *testing.T) {
+	// Then
+	assert.PanicsWithValue(t, "webserver: unknown param source 7", func() {
+		webserver.NewServer().SetParamPrecedence(webserver.ParamSource(7))
+	})
+}
+
+func TestShouldProvideMatchedPattern(t *testing.T) {
+	// Given
+	var patterns []string
+
+	handler := func(req *webserver.Request, res *webserver.Response) {
+		patterns = append(patterns, req.MatchedPattern())
+	}
+	server := webserver.NewServer().Get("/users/{id:int}", handler).Get("/users/**", handler).Fallback(handler)
+
+	// When
+	for _, path := range []string{"/users/1", "/users/john/posts", "/other"} {
+		server.TestHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Then
+	assert.Equal(t, []string{"/users/{id:int}", "/users/**", ""}, patterns)
+}
@@ -0,0 +1,992 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ecromaneli-golang/http/webserver"
+	"github.com/stretchr/testify/assert"
+)
+
+type sendTarget struct {
+	Name string `json:"name" xml:"name"`
+}
+
+func sendHandler(req *webserver.Request, res *webserver.Response) {
+	res.Send(sendTarget{Name: "john"})
+}
+
+func TestShouldSendJSONByDefault(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: sendHandler}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+	assert.JSONEq(t, `{"name":"john"}`, body)
+}
+
+func TestShouldSendJSONWhenAccepted(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: sendHandler, RequestHeaders: map[string]string{"Accept": "application/xml;q=0.5, application/json"}}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+	assert.JSONEq(t, `{"name":"john"}`, body)
+}
+
+func TestShouldSendXMLWhenAccepted(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: sendHandler, RequestHeaders: map[string]string{"Accept": "text/html, application/xml;q=0.9"}}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "application/xml; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "<sendTarget><name>john</name></sendTarget>", body)
+}
+
+func TestShouldSendWithoutReadingRequestBody(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerMethod:       http.MethodPost,
+		RequestMethod:      http.MethodPost,
+		RequestContentType: webserver.ContentTypeFormUrlEncoded,
+		RequestHeaders:     map[string]string{"Accept": "application/xml"},
+		RequestBody:        []byte("param=value"),
+	}
+
+	var read bool
+
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		body := &trackedBody{Reader: req.Raw.Body}
+		req.Raw.Body = io.NopCloser(body)
+
+		sendHandler(req, res)
+		read = body.read
+	}
+
+	res, _, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "application/xml; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+	assert.False(t, read)
+}
+
+func TestShouldEndWithStatusAndNoBody(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.End(http.StatusNoContent)
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestShouldRespectStatusOnNoBody(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Status(http.StatusAccepted).NoBody()
+	}}
+
+	res, _, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+}
+
+func TestShouldCommitStatusWhenHandlerDoesNotWrite(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Status(http.StatusCreated)
+	}}
+
+	res, _, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+}
+
+func TestShouldKeepHeadersSetAfterStatus(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Status(http.StatusCreated).Header("X-Test", "value").WriteText("created")
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "value", res.Header.Get("X-Test"))
+	assert.Equal(t, "created", body)
+}
+
+func TestShouldAnswerStatusShortcuts(t *testing.T) {
+	// Given
+	shortcuts := map[int]func(res *webserver.Response) *webserver.Response{
+		http.StatusBadRequest:          (*webserver.Response).BadRequest,
+		http.StatusUnauthorized:        (*webserver.Response).Unauthorized,
+		http.StatusForbidden:           (*webserver.Response).Forbidden,
+		http.StatusNotFound:            (*webserver.Response).NotFoundStatus,
+		http.StatusInternalServerError: (*webserver.Response).InternalError,
+	}
+
+	for status, shortcut := range shortcuts {
+		server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+			shortcut(res).WriteText("body")
+		})
+
+		// When
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// Then
+		assert.Equal(t, status, recorder.Code)
+		assert.Equal(t, "body", recorder.Body.String())
+	}
+}
+
+func TestShouldAnswerCreatedWithLocation(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Created("/users/1").WriteJSON(map[string]int{"id": 1})
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "/users/1", res.Header.Get("Location"))
+	assert.JSONEq(t, `{"id":1}`, body)
+}
+
+func TestShouldAnswerNoContent(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.NoContent()
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestShouldDefaultTextCharsetToUTF8(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.WriteText("text")
+	}}
+
+	res, _, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+}
+
+func TestShouldDefaultJSONCharsetToUTF8(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.WriteJSON("text")
+	}}
+
+	res, _, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+}
+
+func TestShouldUseConfiguredCharset(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Header(webserver.ContentTypeHeader, "text/html").Charset("iso-8859-1").WriteText("<p>text</p>")
+	}}
+
+	res, _, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "text/html; charset=iso-8859-1", res.Header.Get(webserver.ContentTypeHeader))
+}
+
+func TestShouldNotDuplicateCharset(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Header(webserver.ContentTypeHeader, "text/csv; charset=utf-16").WriteText("a,b")
+	}}
+
+	res, _, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "text/csv; charset=utf-16", res.Header.Get(webserver.ContentTypeHeader))
+}
+
+func TestShouldRenderFile(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerFS:      http.FS(fstest.MapFS{"index.html": {Data: []byte("<p>${name} ${unknown}</p>")}}),
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) { res.View("name", "john").Render("index.html") },
+	}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "text/html", res.Header.Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "<p>john ${unknown}</p>", body)
+}
+
+func TestShouldReturnNotFoundWhenRenderingMissingFile(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerFS:      http.FS(fstest.MapFS{}),
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) { res.Render("index.html") },
+	}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusNotFound))
+}
+
+func TestShouldRenderFileReturningError(t *testing.T) {
+	// Given
+	fileSystem := http.FS(fstest.MapFS{"index.html": {Data: []byte("<p>${name}</p>")}})
+
+	// When
+	test := WebServerTest{ServerFS: fileSystem}
+	test2 := WebServerTest{ServerFS: fileSystem}
+
+	test.ServerSetup = func(server *webserver.Server) {
+		server.HandleE(http.MethodGet, "/page", func(req *webserver.Request, res *webserver.Response) error {
+			return res.View("name", "john").RenderE("index.html")
+		})
+	}
+	test.RequestPath = "/page"
+
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		err := res.RenderE("missing.html")
+		assert.ErrorContains(t, err, "404")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	}
+
+	_, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "<p>john</p>", body)
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldRenderFileFromGivenFS(t *testing.T) {
+	// Given
+	bundle := fstest.MapFS{"widget.html": {Data: []byte("<div>${name}</div>")}}
+
+	// When
+	test := WebServerTest{
+		ServerFS: http.FS(fstest.MapFS{}),
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			res.View("name", "john").RenderFS(bundle, "widget.html")
+		},
+	}
+	test2 := WebServerTest{
+		ServerSetup:   func(server *webserver.Server) { server.SetLogOutput(io.Discard) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) { res.RenderFS(bundle, "missing.html") },
+	}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "text/html", res.Header.Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "<div>john</div>", body)
+	assert.ErrorContains(t, test2.Do(), http.StatusText(http.StatusNotFound))
+}
+
+func TestShouldReturnInternalErrorWhenJSONEncodingFails(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.WriteJSON(make(chan int))
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), body)
+}
+
+func TestShouldRedirectKeepingQuery(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerPattern: "/{page}",
+		RequestPath:   "/old?y=1",
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			if req.Param("page") == "old" {
+				res.RedirectKeepingQuery("/new", http.StatusFound)
+				return
+			}
+			res.WriteText(req.Param("page") + " " + req.Param("y"))
+		},
+	}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "/new?y=1", res.Request.URL.RequestURI())
+	assert.Equal(t, "new 1", body)
+}
+
+func TestShouldWriteIndentedJSON(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.WriteJSONIndent(map[string]int{"a": 1}, "  ")
+	}}
+	test2 := WebServerTest{
+		ServerSetup:   func(server *webserver.Server) { server.SetJSONIndent("\t") },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) { res.WriteJSON(map[string]int{"a": 1}) },
+	}
+
+	_, body, err := test.DoAndReadBody()
+	_, body2, err2 := test2.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	panicIfNotNil(err2)
+	assert.Equal(t, "{\n  \"a\": 1\n}\n", body)
+	assert.Equal(t, "{\n\t\"a\": 1\n}\n", body2)
+}
+
+func TestShouldConfigureJSONHTMLEscaping(t *testing.T) {
+	// When
+	handler := func(req *webserver.Request, res *webserver.Response) { res.WriteJSON("<a>") }
+
+	test := WebServerTest{ServerHandler: handler}
+	test2 := WebServerTest{ServerHandler: handler, ServerSetup: func(server *webserver.Server) { server.SetEscapeHTML(false) }}
+
+	_, body, err := test.DoAndReadBody()
+	_, body2, err2 := test2.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	panicIfNotNil(err2)
+	assert.Equal(t, "\"\\u003ca\\u003e\"\n", body)
+	assert.Equal(t, "\"<a>\"\n", body2)
+}
+
+func TestShouldWriteJSONWithoutEscapingHTML(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.WriteJSONRaw(map[string]string{"url": "http://localhost/?a=1&b=2"})
+	}}
+
+	_, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "{\"url\":\"http://localhost/?a=1&b=2\"}\n", body)
+}
+
+func TestShouldWriteMultipartResponse(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		writer, err := res.Multipart()
+		panicIfNotNil(err)
+
+		for _, content := range []string{"part1", "part2"} {
+			part, err := writer.CreatePart(textproto.MIMEHeader{webserver.ContentTypeHeader: {"text/plain"}})
+			panicIfNotNil(err)
+			part.Write([]byte(content))
+		}
+	}}
+
+	_, res, err := test.DoAndGetDetails()
+	panicIfNotNil(err)
+	defer res.Body.Close()
+
+	// Then
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get(webserver.ContentTypeHeader))
+	panicIfNotNil(err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	reader := multipart.NewReader(res.Body, params["boundary"])
+
+	for _, expected := range []string{"part1", "part2"} {
+		part, err := reader.NextPart()
+		panicIfNotNil(err)
+
+		content, _ := io.ReadAll(part)
+		assert.Equal(t, "text/plain", part.Header.Get(webserver.ContentTypeHeader))
+		assert.Equal(t, expected, string(content))
+	}
+
+	_, err = reader.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestShouldCommitBufferedResponseOnSuccess(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Buffer().Status(http.StatusCreated).Header("X-Buffered", "true").WriteText("buffered")
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "true", res.Header.Get("X-Buffered"))
+	assert.Equal(t, "buffered", body)
+}
+
+func TestShouldDiscardBufferedResponseOnPanic(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Header("X-Before", "true").Buffer()
+		res.Status(http.StatusCreated).Header("X-Partial", "true").WriteText("partial")
+		panic("failed")
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Equal(t, "true", res.Header.Get("X-Before"))
+	assert.Empty(t, res.Header.Get("X-Partial"))
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), body)
+}
+
+func TestShouldDiscardBufferedResponse(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Buffer().Status(http.StatusAccepted).WriteText("discarded")
+		res.Discard().WriteText("kept")
+	}}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "kept", body)
+}
+
+func TestShouldNotWriteBodyForNoContentAndNotModified(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		// When
+		test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			res.Status(status).NoBody()
+			res.NoBody()
+		}}
+
+		res, body, _ := test.DoAndReadBody()
+
+		// Then
+		assert.Equal(t, status, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Content-Length"))
+		assert.Empty(t, body)
+	}
+}
+
+func TestShouldBuildAndFlushEvent(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Headers(webserver.EventStreamHeader)
+		panicIfNotNil(res.Event().ID("1").Name("update").Retry(3000).Data(sendTarget{Name: "john"}).Flush())
+	}}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, webserver.ContentTypeEventStream, res.Header.Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "id: 1\nevent: update\nretry: 3000\ndata: {\"name\":\"john\"}\n\n", body)
+}
+
+type closedWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (this closedWriter) Write(data []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestShouldReturnWriteErrorOnFlush(t *testing.T) {
+	// Given
+	var flushErr error
+	var isDone bool
+
+	server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		flushErr = res.FlushText("data")
+		isDone = req.IsDone()
+	})
+
+	// When
+	server.TestHandler().ServeHTTP(closedWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Then
+	assert.ErrorContains(t, flushErr, "broken pipe")
+	assert.True(t, isDone)
+}
+
+func TestShouldWriteEventFieldsInOrder(t *testing.T) {
+	// Given
+	event := webserver.Event{Data: "value", Retry: 10, Name: "update", ID: "1"}
+
+	// Then
+	assert.Equal(t, "id: 1\nevent: update\nretry: 10\ndata: \"value\"", event.ToString())
+}
+
+func TestShouldWriteEventUsingSSEConfig(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.SSEConfig(webserver.SSEConfig{LineEnding: "\r\n"})
+		panicIfNotNil(res.FlushEvent(&webserver.Event{ID: "1", Name: "update", Data: 1}))
+
+		res.SSEConfig(webserver.SSEConfig{Separator: "\n\n\n"})
+		panicIfNotNil(res.FlushEvent(&webserver.Event{Name: "update", Data: 2}))
+	}}
+
+	_, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "id: 1\r\nevent: update\r\ndata: 1\r\n\r\nevent: update\ndata: 2\n\n\n", body)
+}
+
+func TestShouldOnlyLogPanicAfterFlushing(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) { server.SetLogOutput(io.Discard) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			res.Headers(webserver.EventStreamHeader)
+			panicIfNotNil(res.FlushEvent(&webserver.Event{Name: "update", Data: 1}))
+			panic("stream failed")
+		},
+	}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "event: update\ndata: 1\n\n", body)
+}
+
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	targets []string
+	opts    []*http.PushOptions
+}
+
+func (this *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	this.targets = append(this.targets, target)
+	this.opts = append(this.opts, opts)
+	return nil
+}
+
+func TestShouldPushUsingUnderlyingWriter(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		panicIfNotNil(res.Push("/style.css", nil))
+	})
+
+	recorder := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+	// When
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{"/style.css"}, recorder.targets)
+}
+
+func TestShouldPushOnlyThroughPusherWriter(t *testing.T) {
+	// Given
+	opts := &http.PushOptions{Header: http.Header{"Accept-Encoding": {"gzip"}}}
+	var pushErr, unsupportedErr error
+
+	server := webserver.NewServer().
+		Get("/push", func(req *webserver.Request, res *webserver.Response) { pushErr = res.Push("/style.css", opts) }).
+		Get("/plain", func(req *webserver.Request, res *webserver.Response) { unsupportedErr = res.Push("/style.css", nil) })
+
+	// When
+	pusher := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	server.TestHandler().ServeHTTP(pusher, httptest.NewRequest(http.MethodGet, "/push", nil))
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/plain", nil))
+
+	// Then
+	assert.NoError(t, pushErr)
+	assert.Equal(t, []string{"/style.css"}, pusher.targets)
+	assert.Len(t, pusher.opts, 1)
+	assert.Same(t, opts, pusher.opts[0])
+	assert.ErrorIs(t, unsupportedErr, http.ErrNotSupported)
+}
+
+func TestShouldDetectHTTP2(t *testing.T) {
+	// Given
+	var isHTTP2 bool
+
+	server := httptest.NewUnstartedServer(webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		isHTTP2 = req.IsHTTP2()
+	}).TestHandler())
+
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	// When
+	res, err := server.Client().Get(server.URL)
+	panicIfNotNil(err)
+	res.Body.Close()
+
+	// Then
+	assert.Equal(t, "HTTP/2.0", res.Proto)
+	assert.True(t, isHTTP2)
+}
+
+func TestShouldCancelContextWhenStreamingClientDisconnects(t *testing.T) {
+	configs := []struct {
+		name    string
+		http2   bool
+		setup   func(server *webserver.Server)
+		headers map[string]string
+	}{
+		{name: "HTTP/1.1"},
+		{name: "HTTP/2.0", http2: true},
+		{name: "client timeout", setup: func(server *webserver.Server) {
+			server.EnableClientTimeoutHeader(time.Minute).SetLogOutput(io.Discard)
+		}, headers: map[string]string{"X-Timeout": "60000"}},
+		{name: "path cleaning", setup: func(server *webserver.Server) { server.SetPathCleaning(true) }},
+	}
+
+	for _, config := range configs {
+		// Given
+		started, released := make(chan bool, 1), make(chan bool, 1)
+
+		webServer := webserver.NewServer().Get("/events", func(req *webserver.Request, res *webserver.Response) {
+			// The client timeout buffers the response, so only the other configs stream
+			if res.SupportFlusher() {
+				res.Headers(webserver.EventStreamHeader)
+				panicIfNotNil(res.FlushEvent(&webserver.Event{Data: "subscribed"}))
+			}
+
+			started <- true
+			<-req.Context().Done()
+			released <- true
+		})
+
+		if config.setup != nil {
+			config.setup(webServer)
+		}
+
+		server := httptest.NewUnstartedServer(webServer.TestHandler())
+		server.EnableHTTP2 = config.http2
+		server.StartTLS()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+		panicIfNotNil(err)
+
+		for name, value := range config.headers {
+			req.Header.Set(name, value)
+		}
+
+		go func() {
+			if res, err := server.Client().Do(req); err == nil {
+				res.Body.Close()
+			}
+		}()
+
+		// When
+		<-started
+		cancel()
+
+		// Then
+		select {
+		case <-released:
+		case <-time.After(time.Second):
+			t.Errorf("the context was not canceled with %s", config.name)
+		}
+
+		server.Close()
+	}
+}
+
+func TestShouldStreamChunks(t *testing.T) {
+	// Given
+	read := make(chan bool)
+
+	server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		panicIfNotNil(res.Chunk([]byte("line1\n")))
+		<-read
+		panicIfNotNil(res.Chunk([]byte("line2\n")))
+	})
+
+	addr, stop, err := server.ListenAndServeReady()
+	panicIfNotNil(err)
+	defer stop()
+
+	// When
+	res, err := http.Get("http://" + addr)
+	panicIfNotNil(err)
+	defer res.Body.Close()
+
+	reader := bufio.NewReader(res.Body)
+
+	line1, err := reader.ReadString('\n')
+	panicIfNotNil(err)
+	read <- true
+
+	line2, err := reader.ReadString('\n')
+	panicIfNotNil(err)
+
+	// Then
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "line1\n", line1)
+	assert.Equal(t, "line2\n", line2)
+}
+
+func TestShouldAppendOrReplaceHeaders(t *testing.T) {
+	// When
+	test := WebServerTest{ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+		res.Header("X-Append", "1").Header("X-Append", "2")
+		res.SetHeader("X-Replace", "1").SetHeader("X-Replace", "2")
+		res.Header("X-Deleted", "1").DelHeader("X-Deleted")
+		res.SetHeader(webserver.ContentTypeHeader, webserver.ContentTypeJson).WriteText("{}")
+	}}
+
+	_, res, err := test.DoAndGetDetails()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, []string{"1", "2"}, res.Header.Values("X-Append"))
+	assert.Equal(t, []string{"2"}, res.Header.Values("X-Replace"))
+	assert.Empty(t, res.Header.Values("X-Deleted"))
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+}
+
+func TestShouldSendDeclaredContentLength(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		res.ContentLength(5)
+		panicIfNotNil(res.Chunk([]byte("he")))
+		res.Write([]byte("llo"))
+	})
+
+	addr, stop, err := server.ListenAndServeReady()
+	panicIfNotNil(err)
+	defer stop()
+
+	// When
+	res, err := http.Get("http://" + addr)
+	panicIfNotNil(err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, int64(5), res.ContentLength)
+	assert.Empty(t, res.TransferEncoding)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestShouldSendFileWithCachingHeaders(t *testing.T) {
+	// Given
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fileSystem := http.FS(fstest.MapFS{"app.css": {Data: []byte("body{margin:0}"), ModTime: modTime}})
+
+	server := webserver.NewServerWithFS(fileSystem).Get("/app.css", func(req *webserver.Request, res *webserver.Response) {
+		res.SendFile("app.css", webserver.CacheOptions{MaxAge: time.Hour, Public: true})
+	})
+
+	// When
+	first := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/app.css", nil))
+
+	conditional := httptest.NewRequest(http.MethodGet, "/app.css", nil)
+	conditional.Header.Set("If-None-Match", first.Header().Get("ETag"))
+
+	second := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(second, conditional)
+
+	ranged := httptest.NewRequest(http.MethodGet, "/app.css", nil)
+	ranged.Header.Set("Range", "bytes=0-3")
+
+	partial := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(partial, ranged)
+
+	// Then
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "body{margin:0}", first.Body.String())
+	assert.Equal(t, "text/css; charset=utf-8", first.Header().Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "public, max-age=3600", first.Header().Get("Cache-Control"))
+	assert.Equal(t, modTime.Format(http.TimeFormat), first.Header().Get("Last-Modified"))
+	assert.NotEmpty(t, first.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusNotModified, second.Code)
+	assert.Empty(t, second.Body.String())
+
+	assert.Equal(t, http.StatusPartialContent, partial.Code)
+	assert.Equal(t, "body", partial.Body.String())
+}
+
+func TestShouldSendPrecompressedSidecarFile(t *testing.T) {
+	// Given
+	fileSystem := http.FS(fstest.MapFS{
+		"app.css":    {Data: []byte("body{margin:0}")},
+		"app.css.gz": {Data: []byte("gzip data")},
+	})
+
+	server := webserver.NewServerWithFS(fileSystem).Get("/app.css", func(req *webserver.Request, res *webserver.Response) {
+		res.SendFile("app.css", webserver.CacheOptions{})
+	})
+
+	// When
+	accepting := httptest.NewRequest(http.MethodGet, "/app.css", nil)
+	accepting.Header.Set("Accept-Encoding", "gzip")
+
+	gzipped := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(gzipped, accepting)
+
+	plain := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(plain, httptest.NewRequest(http.MethodGet, "/app.css", nil))
+
+	// Then
+	assert.Equal(t, "gzip data", gzipped.Body.String())
+	assert.Equal(t, "gzip", gzipped.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/css; charset=utf-8", gzipped.Header().Get(webserver.ContentTypeHeader))
+
+	assert.Equal(t, "body{margin:0}", plain.Body.String())
+	assert.Empty(t, plain.Header().Get("Content-Encoding"))
+	assert.NotEqual(t, plain.Header().Get("ETag"), gzipped.Header().Get("ETag"))
+}
+
+func TestShouldFormatCacheOptions(t *testing.T) {
+	// Then
+	assert.Equal(t, "no-cache", webserver.CacheOptions{}.String())
+	assert.Equal(t, "no-store", webserver.CacheOptions{NoStore: true, MaxAge: time.Hour}.String())
+	assert.Equal(t, "private, max-age=60", webserver.CacheOptions{MaxAge: time.Minute}.String())
+	assert.Equal(t, "public, max-age=31536000, immutable", webserver.CacheOptions{MaxAge: 365 * 24 * time.Hour, Public: true, Immutable: true}.String())
+}
+
+func TestShouldAutodetectContentType(t *testing.T) {
+	// Given
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	server := webserver.NewServer().DisableContentSniffing().Get("/", func(req *webserver.Request, res *webserver.Response) {
+		res.Autodetect(png).Write(png)
+	})
+	recorder := httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Then
+	assert.Equal(t, "image/png", recorder.Header().Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+}
+
+func TestShouldNotSendNoSniffByDefault(t *testing.T) {
+	// When
+	res, _, err := WebServerTest{}.DoAndReadBody()
+	panicIfNotNil(err)
+
+	// Then
+	assert.Empty(t, res.Header.Get("X-Content-Type-Options"))
+}
+
+func TestShouldKeepJSONContentTypeWithErrorStatus(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			res.Status(http.StatusBadRequest).WriteJSON(map[string]string{"error": "invalid"})
+		},
+	}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	assert.ErrorContains(t, err, http.StatusText(http.StatusBadRequest))
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get(webserver.ContentTypeHeader))
+	assert.JSONEq(t, `{"error":"invalid"}`, body)
+}
+
+func TestShouldWriteJSONP(t *testing.T) {
+	// Given
+	server := webserver.NewServer().SetLogOutput(io.Discard).Get("/", func(req *webserver.Request, res *webserver.Response) {
+		res.JSONP(req.Param("cb"), map[string]string{"name": "john"})
+	})
+
+	request := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	// When
+	given, fromParam, plain := request("/?cb=jQuery.cb_1"), request("/?callback=$handle"), request("/")
+
+	// Then
+	assert.Equal(t, http.StatusOK, given.Code)
+	assert.Equal(t, "application/javascript; charset=utf-8", given.Header().Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "nosniff", given.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, `/**/jQuery.cb_1({"name":"john"});`, given.Body.String())
+	assert.Equal(t, `/**/$handle({"name":"john"});`, fromParam.Body.String())
+	assert.Equal(t, "application/json; charset=utf-8", plain.Header().Get(webserver.ContentTypeHeader))
+	assert.JSONEq(t, `{"name":"john"}`, plain.Body.String())
+}
+
+func TestShouldRejectUnsafeJSONPCallback(t *testing.T) {
+	// Given
+	server := webserver.NewServer().SetLogOutput(io.Discard).Get("/", func(req *webserver.Request, res *webserver.Response) {
+		res.JSONP("", "value")
+	})
+
+	for _, callback := range []string{"alert(1);cb", "cb<script>", "1cb", "a..b", "cb%0A"} {
+		recorder := httptest.NewRecorder()
+
+		// When
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?callback="+url.QueryEscape(callback), nil))
+
+		// Then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, callback)
+		assert.NotContains(t, recorder.Body.String(), callback)
+	}
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	value := map[string]any{"id": 1, "name": "name", "tags": []string{"a", "b", "c"}}
+	handler := webserver.NewServer().Get("/", func(req *webserver.Request, res *webserver.Response) { res.WriteJSON(value) }).TestHandler()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
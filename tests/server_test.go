@@ -0,0 +1,765 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"embed"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecromaneli-golang/http/webserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldLogErrorsAsJSON(t *testing.T) {
+	// Given
+	output := &bytes.Buffer{}
+
+	// When
+	test := WebServerTest{
+		ServerPattern: "/fail",
+		RequestPath:   "/fail",
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			webserver.NewHTTPError(http.StatusConflict, "conflicting state").Panic()
+		},
+		ServerSetup: func(server *webserver.Server) {
+			server.SetLogFormat(webserver.LogFormatJSON).SetLogOutput(output)
+		},
+	}
+
+	_, _, err := test.DoAndReadBody()
+
+	// Then
+	assert.ErrorContains(t, err, http.StatusText(http.StatusConflict))
+
+	var entry map[string]any
+	assert.NoError(t, json.Unmarshal(output.Bytes(), &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, "webserver", entry["logger"])
+	assert.Equal(t, "[409] conflicting state", entry["message"])
+	assert.Equal(t, float64(http.StatusConflict), entry["status"])
+	assert.Equal(t, http.MethodGet, entry["method"])
+	assert.Equal(t, "/fail", entry["path"])
+	assert.NotEmpty(t, entry["time"])
+}
+
+func TestShouldLogErrorsAsText(t *testing.T) {
+	// Given
+	output := &bytes.Buffer{}
+
+	// When
+	test := WebServerTest{
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			webserver.NewHTTPError(http.StatusConflict, "conflicting state").Panic()
+		},
+		ServerSetup: func(server *webserver.Server) {
+			server.SetLogOutput(output)
+		},
+	}
+
+	test.Do()
+
+	// Then
+	assert.Contains(t, output.String(), "- ERROR webserver: [409] conflicting state status=409 method=GET path=/")
+}
+
+func TestShouldRejectUnknownLogFormat(t *testing.T) {
+	assert.Panics(t, func() { webserver.NewServer().SetLogFormat("xml") })
+}
+
+func TestShouldRedirectToTrailingSlashKeepingQuery(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerPattern: "/x",
+		RequestPath:   "/x?y=1",
+		ServerSetup:   func(server *webserver.Server) { server.SetTrailingSlashRedirect(true) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) { res.WriteText(req.Param("y")) },
+	}
+
+	res, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "/x/?y=1", res.Request.URL.RequestURI())
+	assert.Equal(t, http.StatusMovedPermanently, res.Request.Response.StatusCode)
+	assert.Equal(t, "1", body)
+}
+
+func TestShouldNotRedirectToTrailingSlashWhenNoRouteMatches(t *testing.T) {
+	// Given
+	server := webserver.NewServer().SetLogOutput(io.Discard).SetTrailingSlashRedirect(true).
+		Get("/x/{id:int}", func(req *webserver.Request, res *webserver.Response) {})
+
+	// When
+	recorder := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/x/abc", nil))
+
+	// Then
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Location"))
+}
+
+type contextKey struct{}
+
+func TestShouldUseBaseContextOnRequests(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) {
+			server.SetBaseContext(func(l net.Listener) context.Context {
+				return context.WithValue(context.Background(), contextKey{}, "base value")
+			})
+		},
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			res.WriteText(req.Context().Value(contextKey{}).(string))
+		},
+	}
+
+	_, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "base value", body)
+}
+
+func TestShouldNotifyConnectionStates(t *testing.T) {
+	// Given
+	var mutex sync.Mutex
+	var states []http.ConnState
+
+	// When
+	test := WebServerTest{ServerSetup: func(server *webserver.Server) {
+		server.SetConnState(func(conn net.Conn, state http.ConnState) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			states = append(states, state)
+		})
+	}}
+
+	panicIfNotNil(test.Do())
+
+	// Then
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Contains(t, states, http.StateNew)
+	assert.Contains(t, states, http.StateActive)
+}
+
+func TestShouldCloseConnectionsWhenKeepAlivesAreDisabled(t *testing.T) {
+	// When
+	test := WebServerTest{}
+	test2 := WebServerTest{ServerSetup: func(server *webserver.Server) { server.SetKeepAlivesEnabled(false) }}
+
+	_, res, err := test.DoAndGetDetails()
+	_, res2, err2 := test2.DoAndGetDetails()
+
+	// Then
+	panicIfNotNil(err)
+	panicIfNotNil(err2)
+	assert.False(t, res.Close)
+	assert.True(t, res2.Close)
+}
+
+func TestShouldRejectHeadersLargerThanLimit(t *testing.T) {
+	// When
+	test := WebServerTest{RequestHeaders: map[string]string{"X-Large": strings.Repeat("a", 8*1024)}}
+	test2 := test
+	test2.ServerSetup = func(server *webserver.Server) { server.SetMaxHeaderBytes(1024) }
+
+	_, res, err := test.DoAndGetDetails()
+	_, res2, _ := test2.DoAndGetDetails()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, res2.StatusCode)
+}
+
+func TestShouldListenOnEphemeralPortAndStop(t *testing.T) {
+	// Given
+	server := webserver.NewServer().WriteText("/", "ready")
+
+	// When
+	addr, stop, err := server.ListenAndServeReady()
+	panicIfNotNil(err)
+
+	res, err := http.Get("http://" + addr)
+	panicIfNotNil(err)
+	defer res.Body.Close()
+
+	stop()
+
+	// Then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, err = http.Get("http://" + addr)
+	assert.Error(t, err)
+}
+
+func TestShouldRegisterRouteForManyMethodsThroughRegistrar(t *testing.T) {
+	// Given
+	handler := func(req *webserver.Request, res *webserver.Response) { res.WriteText(req.Raw.Method) }
+
+	// When
+	test := WebServerTest{
+		ServerPattern: "/other",
+		ServerSetup:   func(server *webserver.Server) { server.Methods(http.MethodGet, http.MethodPost).Handle("/x", handler) },
+		RequestPath:   "/x",
+	}
+	test2 := test
+	test2.RequestMethod = http.MethodPost
+	test3 := test
+	test3.RequestMethod = http.MethodPut
+
+	// Then
+	_, body, err := test.DoAndReadBody()
+	panicIfNotNil(err)
+	assert.Equal(t, http.MethodGet, body)
+
+	_, body, err = test2.DoAndReadBody()
+	panicIfNotNil(err)
+	assert.Equal(t, http.MethodPost, body)
+
+	assert.ErrorContains(t, test3.Do(), http.StatusText(http.StatusMethodNotAllowed))
+}
+
+func TestShouldTimeoutUsingClientHeader(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup:    func(server *webserver.Server) { server.EnableClientTimeoutHeader(time.Second).SetLogOutput(io.Discard) },
+		ServerHandler:  func(req *webserver.Request, res *webserver.Response) { time.Sleep(200 * time.Millisecond) },
+		RequestHeaders: map[string]string{"X-Timeout": "50"},
+	}
+
+	start := time.Now()
+	err := test.Do()
+
+	// Then
+	assert.ErrorContains(t, err, http.StatusText(http.StatusServiceUnavailable))
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestShouldCapClientTimeoutByServerMaximum(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) {
+			server.EnableClientTimeoutHeader(50 * time.Millisecond).SetLogOutput(io.Discard)
+		},
+		ServerHandler:  func(req *webserver.Request, res *webserver.Response) { <-req.Context().Done() },
+		RequestHeaders: map[string]string{"X-Timeout": "60000"},
+	}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusServiceUnavailable))
+}
+
+func TestShouldNotAnswerTimeoutWhenClientDisconnects(t *testing.T) {
+	// Given
+	waiting := func(started chan struct{}) webserver.Handler {
+		return func(req *webserver.Request, res *webserver.Response) {
+			close(started)
+			<-req.Context().Done()
+		}
+	}
+
+	matrix := []struct {
+		name  string
+		setup func(server *webserver.Server, handler webserver.Handler)
+	}{
+		{"client timeout", func(server *webserver.Server, handler webserver.Handler) {
+			server.EnableClientTimeoutHeader(time.Minute).Get("/", handler)
+		}},
+		{"middleware", func(server *webserver.Server, handler webserver.Handler) {
+			server.Get("/", webserver.Timeout(time.Minute, "Upstream took too long")(handler))
+		}},
+	}
+
+	for _, item := range matrix {
+		output := &bytes.Buffer{}
+		started := make(chan struct{})
+
+		server := webserver.NewServer().SetLogOutput(output)
+		item.setup(server, waiting(started))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		req.Header.Set("X-Timeout", "60000")
+
+		go func() {
+			<-started
+			cancel()
+		}()
+
+		// When
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, req)
+
+		// Then
+		assert.NotEqual(t, http.StatusServiceUnavailable, recorder.Code, item.name)
+		assert.NotEqual(t, http.StatusGatewayTimeout, recorder.Code, item.name)
+		assert.NotContains(t, output.String(), "ERROR", item.name)
+	}
+}
+
+func TestShouldRespondWithinClientTimeout(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) { server.EnableClientTimeoutHeader(time.Second) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			res.Header("X-Custom", "value").Status(http.StatusCreated).WriteText("done")
+		},
+		RequestHeaders: map[string]string{"X-Timeout": "500"},
+	}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "value", res.Header.Get("X-Custom"))
+	assert.Equal(t, "done", body)
+}
+
+func TestShouldAnswerErrorReturnedByHandler(t *testing.T) {
+	// When
+	test := WebServerTest{ServerSetup: func(server *webserver.Server) {
+		server.SetLogOutput(io.Discard).HandleE(http.MethodGet, "/missing", func(req *webserver.Request, res *webserver.Response) error {
+			return webserver.NewHTTPError(http.StatusNotFound, "user not found")
+		})
+		server.HandleE(http.MethodGet, "/failing", func(req *webserver.Request, res *webserver.Response) error {
+			return errors.New("database offline")
+		})
+	}}
+	test.RequestPath = "/missing"
+	test2 := test
+	test2.RequestPath = "/failing"
+
+	res, body, err := test.DoAndReadBody()
+	res2, body2, err2 := test2.DoAndReadBody()
+
+	// Then
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, http.StatusText(http.StatusNotFound), body)
+
+	assert.Error(t, err2)
+	assert.Equal(t, http.StatusInternalServerError, res2.StatusCode)
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), body2)
+}
+
+func TestShouldRenderErrorsUsingErrorHandler(t *testing.T) {
+	// When
+	test := WebServerTest{
+		RequestPath: "/missing",
+		ServerSetup: func(server *webserver.Server) {
+			server.SetLogOutput(io.Discard).SetErrorHandler(func(req *webserver.Request, res *webserver.Response, statusCode int, err error) {
+				res.Status(statusCode).WriteJSON(map[string]any{"status": statusCode, "error": err.Error()})
+			})
+			server.HandleE(http.MethodGet, "/missing", func(req *webserver.Request, res *webserver.Response) error {
+				return webserver.NewHTTPError(http.StatusNotFound, "user not found")
+			})
+		},
+	}
+
+	res, body, _ := test.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.JSONEq(t, `{"status":404,"error":"[404] user not found"}`, body)
+}
+
+func TestShouldDropBodyHeadersOnPanicAfterSettingThem(t *testing.T) {
+	// Given
+	server := webserver.NewServer().SetLogOutput(io.Discard).Get("/", func(req *webserver.Request, res *webserver.Response) {
+		res.Status(http.StatusCreated).
+			SetHeader("Content-Type", "application/json").
+			SetHeader("Content-Length", "42").
+			SetHeader("ETag", `"v1"`).
+			SetHeader("X-Custom", "kept")
+		panic(errors.New("database offline"))
+	})
+
+	recorder := httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Then
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Empty(t, recorder.Header().Get("Content-Length"))
+	assert.Empty(t, recorder.Header().Get("ETag"))
+	assert.Equal(t, "kept", recorder.Header().Get("X-Custom"))
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), recorder.Body.String())
+}
+
+func TestShouldAnswerWrappedServerError(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) { server.SetLogOutput(io.Discard) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			panic(fmt.Errorf("loading user: %w", webserver.NewHTTPError(http.StatusNotFound, nil)))
+		},
+	}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusNotFound))
+}
+
+func TestShouldLogAccessUsingFormat(t *testing.T) {
+	// Given
+	output := &bytes.Buffer{}
+
+	server := webserver.NewServer().
+		SetLogOutput(output).
+		EnableAccessLog("%method %path %status %ip took %latency").
+		Get("/users", func(req *webserver.Request, res *webserver.Response) { res.Status(http.StatusCreated) })
+
+	req := httptest.NewRequest(http.MethodGet, "/users?page=1", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	// When
+	server.TestHandler().ServeHTTP(httptest.NewRecorder(), req)
+
+	// Then
+	assert.Regexp(t, `INFO webserver: GET /users 201 203\.0\.113\.7 took [0-9.]+(ns|µs|ms)\n$`, output.String())
+}
+
+func TestShouldResolveClientIP(t *testing.T) {
+	// When
+	test := WebServerTest{RequestHeaders: map[string]string{"X-Real-Ip": "203.0.113.7", "X-Forwarded-For": "10.0.0.1"}}
+	test2 := WebServerTest{}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "203.0.113.7", req.ClientIP())
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "127.0.0.1", req.ClientIP())
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldExposeErrorsOnlyWhenEnabled(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) { server.SetLogOutput(io.Discard) },
+		ServerHandler: func(req *webserver.Request, res *webserver.Response) {
+			panic(errors.New("database offline"))
+		},
+	}
+	test2 := test
+	test2.ServerSetup = func(server *webserver.Server) { server.SetLogOutput(io.Discard).SetExposeErrors(true) }
+
+	res, body, _ := test.DoAndReadBody()
+	res2, body2, _ := test2.DoAndReadBody()
+
+	// Then
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), body)
+
+	assert.Equal(t, http.StatusInternalServerError, res2.StatusCode)
+	assert.Equal(t, "database offline", body2)
+}
+
+type lineWriter chan string
+
+func (this lineWriter) Write(data []byte) (int, error) {
+	select {
+	case this <- string(data):
+	default:
+	}
+
+	return len(data), nil
+}
+
+func TestShouldLogConnectionErrorsToErrorLog(t *testing.T) {
+	// Given
+	certFile, keyFile := writeTestCertificate(t.TempDir())
+	lines := make(lineWriter, 1)
+
+	server := webserver.NewServer().SetErrorLog(log.New(lines, "", 0))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	panicIfNotNil(err)
+	defer listener.Close()
+
+	go server.ServeTLS(listener, certFile, keyFile)
+
+	// When
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	panicIfNotNil(err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("not a handshake\n\n\n\n\n\n"))
+	panicIfNotNil(err)
+
+	// Then
+	select {
+	case line := <-lines:
+		assert.Contains(t, line, "TLS handshake error")
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake error was not logged")
+	}
+}
+
+func writeTestCertificate(dir string) (certFile, keyFile string) {
+	testServer := httptest.NewUnstartedServer(nil)
+	testServer.StartTLS()
+	certificate := testServer.TLS.Certificates[0]
+	testServer.Close()
+
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	panicIfNotNil(err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	panicIfNotNil(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0600))
+	panicIfNotNil(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestShouldRegisterCommaSeparatedMethods(t *testing.T) {
+	// When
+	test := WebServerTest{ServerMethod: "GET, post"}
+	test2 := WebServerTest{ServerMethod: "GET, post", RequestMethod: http.MethodPost}
+	test3 := WebServerTest{ServerMethod: "GET, post", RequestMethod: http.MethodPut}
+
+	// Then
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+	assert.ErrorContains(t, test3.Do(), http.StatusText(http.StatusMethodNotAllowed))
+}
+
+func TestShouldResolveClientIPFromMultiHopForwardedFor(t *testing.T) {
+	// Given
+	var ips []string
+
+	handler := func(req *webserver.Request, res *webserver.Response) { ips = append(ips, req.ClientIP()) }
+	first := webserver.NewServer().Get("/", handler)
+	trusted := webserver.NewServer().Get("/", handler).
+		SetForwardedFor(webserver.ForwardedForTrustedProxies, "10.0.0.0/8", "192.0.2.1")
+
+	request := func(remoteAddr string, lines ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+
+		for _, line := range lines {
+			req.Header.Add("X-Forwarded-For", line)
+		}
+
+		return req
+	}
+
+	// When
+	first.TestHandler().ServeHTTP(httptest.NewRecorder(), request("192.0.2.1:1234", " , 198.51.100.9, 10.0.0.2", "10.0.0.1"))
+
+	// The client forged the left-most hop, only the right-most untrusted one is reliable
+	trusted.TestHandler().ServeHTTP(httptest.NewRecorder(), request("192.0.2.1:1234", "1.1.1.1, 198.51.100.9", "10.0.0.2, 10.0.0.1"))
+	trusted.TestHandler().ServeHTTP(httptest.NewRecorder(), request("192.0.2.1:1234", "10.0.0.3, 10.0.0.2"))
+	trusted.TestHandler().ServeHTTP(httptest.NewRecorder(), request("203.0.113.7:1234", "1.1.1.1"))
+	trusted.TestHandler().ServeHTTP(httptest.NewRecorder(), request("[::ffff:10.0.0.5]:1234"))
+
+	// Then
+	assert.Equal(t, []string{"198.51.100.9", "198.51.100.9", "10.0.0.3", "203.0.113.7", "::ffff:10.0.0.5"}, ips)
+}
+
+func TestShouldPanicOnInvalidTrustedProxy(t *testing.T) {
+	assert.PanicsWithValue(t, "webserver: invalid trusted proxy '10.0.0'", func() {
+		webserver.NewServer().SetForwardedFor(webserver.ForwardedForTrustedProxies, "10.0.0")
+	})
+}
+
+func TestShouldResolveClientIPUsingCustomFunc(t *testing.T) {
+	// When
+	test := WebServerTest{
+		ServerSetup: func(server *webserver.Server) {
+			server.SetRemoteAddrFunc(func(req *http.Request) string { return req.Header.Get("CF-Connecting-IP") })
+		},
+		RequestHeaders: map[string]string{"CF-Connecting-IP": "203.0.113.7", "X-Real-Ip": "10.0.0.1"},
+	}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "203.0.113.7", req.ClientIP())
+	}
+
+	panicIfNotNil(test.Do())
+}
+
+//go:embed testdata/public
+var publicFS embed.FS
+
+func TestShouldRenderFileFromEmbedFS(t *testing.T) {
+	// Given
+	server := webserver.NewServerWithEmbed(publicFS, "testdata/public").Render("/hello", "hello.txt")
+
+	// When
+	recorder := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/hello", nil))
+
+	// Then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello from embed\n", recorder.Body.String())
+}
+
+func TestShouldPanicOnInvalidEmbedRoot(t *testing.T) {
+	// Then
+	assert.Panics(t, func() { webserver.NewServerWithEmbed(publicFS, "../outside") })
+}
+
+func TestShouldListenTwiceOnTheSamePortReusingIt(t *testing.T) {
+	if !slices.Contains([]string{"linux", "darwin", "dragonfly", "freebsd", "netbsd", "openbsd"}, runtime.GOOS) {
+		t.Skip("SO_REUSEPORT is not supported on " + runtime.GOOS)
+	}
+
+	// Given
+	first, err := webserver.ListenReusePort("127.0.0.1:0")
+	panicIfNotNil(err)
+	defer first.Close()
+
+	// When
+	second, err := webserver.ListenReusePort(first.Addr().String())
+	panicIfNotNil(err)
+	defer second.Close()
+
+	server := webserver.NewServer().WriteText("/", "ready")
+	go server.ServeListener(second)
+	first.Close()
+
+	res, err := http.Get("http://" + second.Addr().String())
+	panicIfNotNil(err)
+	defer res.Body.Close()
+
+	// Then
+	assert.Equal(t, first.Addr().String(), second.Addr().String())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestShouldListenAndServeScopedToPattern(t *testing.T) {
+	// Given
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	panicIfNotNil(err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	// When
+	go webserver.ListenAndServeWithPattern(addr, "/api/**", func(req *webserver.Request, res *webserver.Response) {
+		res.WriteText("api")
+	})
+
+	var res *http.Response
+	assert.Eventually(t, func() bool {
+		res, err = http.Get("http://" + addr + "/api/users")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer res.Body.Close()
+
+	outside, err := http.Get("http://" + addr + "/other")
+	panicIfNotNil(err)
+	defer outside.Body.Close()
+
+	// Then
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "api", string(body))
+	assert.Equal(t, http.StatusNotFound, outside.StatusCode)
+}
+
+func TestShouldRegisterRoutesFromTable(t *testing.T) {
+	// Given
+	tag := func(name string) webserver.Middleware {
+		return func(next webserver.Handler) webserver.Handler {
+			return func(req *webserver.Request, res *webserver.Response) {
+				res.Header("X-Chain", name)
+				next(req, res)
+			}
+		}
+	}
+
+	server := webserver.NewServer().Register([]webserver.RouteDef{
+		{Name: "list", Methods: []string{http.MethodGet}, Pattern: "/users", Handler: func(req *webserver.Request, res *webserver.Response) {
+			res.WriteText("list")
+		}},
+		{Name: "create", Methods: []string{http.MethodPost}, Pattern: "/users", Middleware: []webserver.Middleware{tag("outer"), tag("inner")}, Handler: func(req *webserver.Request, res *webserver.Response) {
+			res.Status(http.StatusCreated).WriteText("create")
+		}},
+		{Name: "any", Pattern: "/users/{id}", Handler: func(req *webserver.Request, res *webserver.Response) {
+			res.WriteText(req.Raw.Method + " " + req.Param("id"))
+		}},
+	})
+
+	list, create, anyMethod := httptest.NewRecorder(), httptest.NewRecorder(), httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(list, httptest.NewRequest(http.MethodGet, "/users", nil))
+	server.TestHandler().ServeHTTP(create, httptest.NewRequest(http.MethodPost, "/users", nil))
+	server.TestHandler().ServeHTTP(anyMethod, httptest.NewRequest(http.MethodDelete, "/users/1", nil))
+
+	// Then
+	assert.Equal(t, "list", list.Body.String())
+	assert.Equal(t, http.StatusCreated, create.Code)
+	assert.Equal(t, "create", create.Body.String())
+	assert.Equal(t, []string{"outer", "inner"}, create.Header().Values("X-Chain"))
+	assert.Equal(t, "DELETE 1", anyMethod.Body.String())
+}
+
+func TestShouldPanicRegisteringRouteWithoutHandler(t *testing.T) {
+	assert.PanicsWithValue(t, "webserver: handler must not be nil for route 'broken'", func() {
+		webserver.NewServer().Register([]webserver.RouteDef{{Name: "broken", Pattern: "/"}})
+	})
+}
+
+func TestShouldExposeAddressBoundOnEphemeralPort(t *testing.T) {
+	// Given
+	server := webserver.NewServer().WriteText("/", "ready")
+	assert.Empty(t, server.Addr())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	panicIfNotNil(err)
+	defer listener.Close()
+
+	// When
+	go server.Serve(listener)
+
+	assert.Eventually(t, func() bool { return server.Addr() != "" }, time.Second, 10*time.Millisecond)
+	_, port, err := net.SplitHostPort(server.Addr())
+	panicIfNotNil(err)
+
+	res, err := http.Get("http://" + server.Addr())
+	panicIfNotNil(err)
+	defer res.Body.Close()
+
+	// Then
+	assert.NotEqual(t, "0", port)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestShouldExposeAddressAfterListenAndServeReady(t *testing.T) {
+	// Given
+	server := webserver.NewServer()
+
+	// When
+	addr, stop, err := server.ListenAndServeReady()
+	panicIfNotNil(err)
+	defer stop()
+
+	// Then
+	assert.Equal(t, addr, server.Addr())
+	assert.True(t, strings.HasPrefix(addr, "127.0.0.1:"))
+}
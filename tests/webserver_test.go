@@ -1,8 +1,12 @@
 package tests
 
 import (
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/ecromaneli-golang/http/webserver"
 	"github.com/stretchr/testify/assert"
@@ -132,11 +136,16 @@ func TestShouldAcceptRightDomain(t *testing.T) {
 }
 
 func TestShouldReturnNotFound1(t *testing.T) {
+	// Given
+	server := httptest.NewServer(webserver.NewServer().Get("/", emptyHandler).TestHandler())
+	defer server.Close()
+
 	// When
-	test := WebServerTest{ServerPattern: "/", RequestPath: "/static1"}
+	res, err := http.Get(server.URL + "/static1")
 
 	// Then
-	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusNotFound))
+	panicIfNotNil(err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
 }
 
 func TestShouldReturnNotFound2(t *testing.T) {
@@ -148,11 +157,16 @@ func TestShouldReturnNotFound2(t *testing.T) {
 }
 
 func TestShouldReturnMethodNotAllowed(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Post("/static1/static2/", emptyHandler)
+	recorder := httptest.NewRecorder()
+
 	// When
-	test := WebServerTest{ServerMethod: "POST", ServerPattern: "/static1/static2/", RequestMethod: "GET", RequestPath: "/static1/static2"}
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/static1/static2", nil))
 
 	// Then
-	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusMethodNotAllowed))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, http.StatusText(http.StatusMethodNotAllowed), recorder.Body.String())
 }
 
 func TestShouldReturnMethodNotAllowed2(t *testing.T) {
@@ -163,6 +177,42 @@ func TestShouldReturnMethodNotAllowed2(t *testing.T) {
 	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusMethodNotAllowed))
 }
 
+func TestShouldAnswerOptionsAutomatically(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Get("/users/{id}", emptyHandler).Delete("/users/{id}", emptyHandler)
+	options, notAllowed := httptest.NewRecorder(), httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(options, httptest.NewRequest(http.MethodOptions, "/users/1", nil))
+	server.SetLogOutput(io.Discard).TestHandler().ServeHTTP(notAllowed, httptest.NewRequest(http.MethodPost, "/users/1", nil))
+
+	// Then
+	assert.Equal(t, http.StatusNoContent, options.Code)
+	assert.Equal(t, "GET, DELETE, OPTIONS", options.Header().Get("Allow"))
+	assert.Equal(t, http.StatusMethodNotAllowed, notAllowed.Code)
+	assert.Equal(t, "GET, DELETE, OPTIONS", notAllowed.Header().Get("Allow"))
+}
+
+func TestShouldPreferRegisteredOptionsHandler(t *testing.T) {
+	// Given
+	server := webserver.NewServer().
+		Post("/upload", emptyHandler).
+		Handle(http.MethodOptions, "/upload", func(req *webserver.Request, res *webserver.Response) {
+			res.SetHeader("Allow", strings.Join(req.AllowedMethods(), ", ")).
+				SetHeader("Access-Control-Allow-Headers", "X-Upload-Id").
+				End(http.StatusOK)
+		})
+	recorder := httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodOptions, "/upload", nil))
+
+	// Then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "POST, OPTIONS", recorder.Header().Get("Allow"))
+	assert.Equal(t, "X-Upload-Id", recorder.Header().Get("Access-Control-Allow-Headers"))
+}
+
 func TestShouldParseParams(t *testing.T) {
 	// When
 	test := WebServerTest{
@@ -188,6 +238,55 @@ func TestShouldParseParams(t *testing.T) {
 	panicIfNotNil(test.Do())
 }
 
+func TestShouldReturnMethodNotAllowedAcrossPatterns(t *testing.T) {
+	// Given
+	setup := func(server *webserver.Server) {
+		server.Get("/static1/static2/{p1}", emptyHandler)
+	}
+
+	// When
+	test := WebServerTest{ServerMethod: "POST", ServerPattern: "/static1/**", RequestPath: "/static1/static2/param1/param2", ServerSetup: setup}
+	test2 := WebServerTest{ServerMethod: "POST", ServerPattern: "/static1/**", RequestMethod: "POST", RequestPath: "/static1/static2/param1", ServerSetup: setup}
+	test3 := WebServerTest{ServerMethod: "POST", ServerPattern: "/static1", RequestPath: "/static1"}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusMethodNotAllowed))
+	panicIfNotNil(test2.Do())
+	assert.ErrorContains(t, test3.Do(), http.StatusText(http.StatusMethodNotAllowed))
+}
+
+func TestShouldReturnNotFoundForWildcardTreatedAsFallback(t *testing.T) {
+	// Given
+	defaultServer := webserver.NewServer()
+	defaultServer.Methods(http.MethodGet).Handle("/static1/**", emptyHandler)
+
+	fallbackServer := webserver.NewServer()
+	fallbackServer.Methods(http.MethodGet).TreatAsFallback().Handle("/static1/**", emptyHandler)
+
+	defaultRecorder, fallbackRecorder := httptest.NewRecorder(), httptest.NewRecorder()
+
+	// When
+	defaultServer.TestHandler().ServeHTTP(defaultRecorder, httptest.NewRequest(http.MethodPost, "/static1/a/b", nil))
+	fallbackServer.TestHandler().ServeHTTP(fallbackRecorder, httptest.NewRequest(http.MethodPost, "/static1/a/b", nil))
+
+	// Then
+	assert.Equal(t, http.StatusMethodNotAllowed, defaultRecorder.Code)
+	assert.Equal(t, http.StatusNotFound, fallbackRecorder.Code)
+}
+
+func TestShouldReturnMethodNotAllowedFromOtherRouteWhenWildcardIsFallback(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Post("/static1/{id}", emptyHandler)
+	server.Methods(http.MethodGet).TreatAsFallback().Handle("/static1/**", emptyHandler)
+	recorder := httptest.NewRecorder()
+
+	// When
+	server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/static1/1", nil))
+
+	// Then
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
 // Issue fixed on 0.3.2
 func TestShouldParseDomainParamEvenWithoutPathParam(t *testing.T) {
 	// When
@@ -219,6 +318,645 @@ func TestShouldKeepMethodWhenNotUsingTrailingSlash(t *testing.T) {
 	panicIfNotNil(test.Do())
 }
 
+func TestShouldMatchStaticRouteOnlyExactly(t *testing.T) {
+	// When
+	test := WebServerTest{ServerPattern: "/static1/static2", RequestPath: "/static1/static2/static3"}
+	test2 := WebServerTest{ServerPattern: "/", RequestPath: "/static1"}
+
+	// Then
+	assert.ErrorContains(t, test.Do(), http.StatusText(http.StatusNotFound))
+	assert.ErrorContains(t, test2.Do(), http.StatusText(http.StatusNotFound))
+}
+
+func TestShouldMatchDynamicRouteAddedAfterStaticRoute(t *testing.T) {
+	// Given
+	setup := func(server *webserver.Server) {
+		server.Get("/static1/{p1}", func(req *webserver.Request, res *webserver.Response) {
+			res.WriteText(req.Param("p1"))
+		})
+	}
+
+	// When
+	test := WebServerTest{ServerPattern: "/static1", RequestPath: "/static1/", ServerSetup: setup}
+	test2 := WebServerTest{ServerPattern: "/static1", RequestPath: "/static1/param1", ServerSetup: setup}
+
+	_, body, err := test2.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(test.Do())
+	panicIfNotNil(err)
+	assert.Equal(t, "param1", body)
+}
+
+func TestShouldMatchStaticRouteAddedAfterDynamicRoute(t *testing.T) {
+	// Given
+	setup := func(server *webserver.Server) {
+		server.Get("/static1", func(req *webserver.Request, res *webserver.Response) {
+			res.WriteText("static")
+		})
+	}
+
+	// When
+	test := WebServerTest{ServerPattern: "/static1/{p1}", RequestPath: "/static1/", ServerSetup: setup}
+	test2 := WebServerTest{ServerPattern: "/static1/{p1}", RequestPath: "/static1/param1", ServerSetup: setup}
+
+	_, body, err := test.DoAndReadBody()
+
+	// Then
+	panicIfNotNil(err)
+	assert.Equal(t, "static", body)
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldCaptureRemainingPathInCatchAllParam(t *testing.T) {
+	// When
+	test := WebServerTest{ServerPattern: "/files/{path...}", RequestPath: "/files/a/b/c"}
+	test2 := WebServerTest{ServerPattern: "/files/{path...}", RequestPath: "/files/"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "a/b/c", req.Param("path"))
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "", req.Param("path"))
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldMatchOptionalParamInTheMiddle(t *testing.T) {
+	// When
+	test := WebServerTest{ServerPattern: "/{lang?}/docs/{page}", RequestPath: "/en/docs/intro"}
+	test2 := WebServerTest{ServerPattern: "/{lang?}/docs/{page}", RequestPath: "/docs/intro"}
+	test3 := WebServerTest{ServerPattern: "/{lang?}/docs/{page}", RequestPath: "/en/intro"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "en", req.Param("lang"))
+		assert.Equal(t, "intro", req.Param("page"))
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "", req.Param("lang"))
+		assert.Equal(t, "intro", req.Param("page"))
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+	assert.ErrorContains(t, test3.Do(), http.StatusText(http.StatusNotFound))
+}
+
+func TestShouldPreferBindingOptionalParamWhenAmbiguous(t *testing.T) {
+	// When
+	test := WebServerTest{ServerPattern: "/{o1?}/{p1}", RequestPath: "/value1/value2"}
+	test2 := WebServerTest{ServerPattern: "/{o1?}/{p1}", RequestPath: "/value1"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "value1", req.Param("o1"))
+		assert.Equal(t, "value2", req.Param("p1"))
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "", req.Param("o1"))
+		assert.Equal(t, "value1", req.Param("p1"))
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldCollapseRepeatedSlashesWhenCleaningPath(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Get("/a/{p1}/{p2}", func(req *webserver.Request, res *webserver.Response) {
+		res.WriteText(req.Param("p1") + ":" + req.Param("p2"))
+	})
+
+	// When
+	raw := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(raw, httptest.NewRequest(http.MethodGet, "/a//value1/%2F%2Fvalue2", nil))
+
+	server.SetPathCleaning(true)
+
+	cleaned := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(cleaned, httptest.NewRequest(http.MethodGet, "/a//value1/%2F%2Fvalue2", nil))
+
+	// Then
+	assert.Equal(t, http.StatusTemporaryRedirect, raw.Code, "the mux redirects repeated slashes by itself")
+	assert.Equal(t, http.StatusOK, cleaned.Code)
+	assert.Empty(t, cleaned.Header().Get("Location"))
+	assert.Equal(t, "value1:value2", cleaned.Body.String())
+}
+
+func TestShouldDecodeEncodedSlashOnlyWhenCleaningPath(t *testing.T) {
+	// Given
+	server := webserver.NewServer().Get("/files/{dir}/{name}", func(req *webserver.Request, res *webserver.Response) {
+		res.WriteText(req.Param("dir") + ":" + req.Param("name"))
+	})
+
+	// When
+	raw := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(raw, httptest.NewRequest(http.MethodGet, "/files/docs%2Freadme", nil))
+
+	server.SetPathCleaning(true)
+
+	cleaned := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(cleaned, httptest.NewRequest(http.MethodGet, "/files/docs%2Freadme", nil))
+
+	// Then
+	assert.Equal(t, http.StatusNotFound, raw.Code)
+	assert.Equal(t, http.StatusOK, cleaned.Code)
+	assert.Equal(t, "docs:readme", cleaned.Body.String())
+}
+
+func TestShouldServeUnmatchedPathsUsingFallback(t *testing.T) {
+	// Given
+	server := webserver.NewServer().
+		Get("/users/{id}", func(req *webserver.Request, res *webserver.Response) { res.WriteText("user") }).
+		Get("/static", func(req *webserver.Request, res *webserver.Response) { res.WriteText("static") }).
+		Fallback(func(req *webserver.Request, res *webserver.Response) { res.WriteText("fallback " + req.Raw.URL.Path) })
+
+	// When
+	matched := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(matched, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	unmatched := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(unmatched, httptest.NewRequest(http.MethodGet, "/users/1/posts", nil))
+
+	unmatchedStatic := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(unmatchedStatic, httptest.NewRequest(http.MethodGet, "/static/other", nil))
+
+	notAllowed := httptest.NewRecorder()
+	server.SetLogOutput(io.Discard).TestHandler().ServeHTTP(notAllowed, httptest.NewRequest(http.MethodPost, "/users/1", nil))
+
+	// Then
+	assert.Equal(t, "user", matched.Body.String())
+	assert.Equal(t, http.StatusOK, unmatched.Code)
+	assert.Equal(t, "fallback /users/1/posts", unmatched.Body.String())
+	assert.Equal(t, "fallback /static/other", unmatchedStatic.Body.String())
+	assert.Equal(t, http.StatusMethodNotAllowed, notAllowed.Code)
+}
+
+func TestShouldAnswerUnmatchedPathsUsingPrefixNotFoundHandler(t *testing.T) {
+	// Given
+	server := webserver.NewServer().
+		Get("/api/users/{id}", func(req *webserver.Request, res *webserver.Response) { res.WriteText("user") }).
+		NotFoundHandlerFor("/api/", func(req *webserver.Request, res *webserver.Response) {
+			res.WriteJSON(map[string]string{"error": "not found"})
+		}).
+		Fallback(func(req *webserver.Request, res *webserver.Response) {
+			res.Status(http.StatusNotFound).WriteText("<h1>Not Found</h1>")
+		})
+
+	// When
+	api := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(api, httptest.NewRequest(http.MethodGet, "/api/orders/1", nil))
+
+	apiDeeper := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(apiDeeper, httptest.NewRequest(http.MethodGet, "/api/users/1/posts", nil))
+
+	web := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(web, httptest.NewRequest(http.MethodGet, "/about", nil))
+
+	// Then
+	assert.Equal(t, http.StatusNotFound, api.Code)
+	assert.Equal(t, "application/json; charset=utf-8", api.Header().Get(webserver.ContentTypeHeader))
+	assert.JSONEq(t, `{"error":"not found"}`, api.Body.String())
+	assert.Equal(t, http.StatusNotFound, apiDeeper.Code)
+	assert.JSONEq(t, `{"error":"not found"}`, apiDeeper.Body.String())
+	assert.Equal(t, http.StatusNotFound, web.Code)
+	assert.Equal(t, "<h1>Not Found</h1>", web.Body.String())
+}
+
+func TestShouldAnswerDefaultNotFoundOutsidePrefix(t *testing.T) {
+	// Given
+	server := webserver.NewServer().NotFoundHandlerFor("/api", func(req *webserver.Request, res *webserver.Response) {
+		res.WriteText("api")
+	})
+	recorder := httptest.NewRecorder()
+
+	// When
+	server.SetLogOutput(io.Discard).TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/apix", nil))
+
+	// Then
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.NotEqual(t, "api", recorder.Body.String())
+}
+
+func TestShouldCaptureWildcardTailAsParam(t *testing.T) {
+	// When
+	test := WebServerTest{ServerPattern: "**.0.1/files/**", RequestHost: "127.0.0.1", RequestPath: "/files/a/b/c.txt"}
+	test2 := WebServerTest{ServerPattern: "/files/**", RequestPath: "/files"}
+
+	// Then
+	test.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "a/b/c.txt", req.Param("**"))
+		assert.Equal(t, []string{"a/b/c.txt"}, req.AllParams()["**"])
+	}
+	test2.ServerHandler = func(req *webserver.Request, res *webserver.Response) {
+		assert.Equal(t, "", req.Param("**"))
+	}
+
+	panicIfNotNil(test.Do())
+	panicIfNotNil(test2.Do())
+}
+
+func TestShouldSkipRouteWhenConstraintFails(t *testing.T) {
+	// Given
+	server := webserver.NewServer().SetLogOutput(io.Discard).
+		Get("/{id:int}", func(req *webserver.Request, res *webserver.Response) { res.WriteText("id " + req.Param("id")) }).
+		Get("/{slug}", func(req *webserver.Request, res *webserver.Response) { res.WriteText("slug " + req.Param("slug")) }).
+		Get("/users/{id:uuid}", emptyHandler)
+
+	// When
+	id := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(id, httptest.NewRequest(http.MethodGet, "/12", nil))
+
+	slug := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(slug, httptest.NewRequest(http.MethodGet, "/abc", nil))
+
+	notFound := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(notFound, httptest.NewRequest(http.MethodGet, "/users/abc", nil))
+
+	// Then
+	assert.Equal(t, "id 12", id.Body.String())
+	assert.Equal(t, "slug abc", slug.Body.String())
+	assert.Equal(t, http.StatusNotFound, notFound.Code)
+}
+
+func TestShouldPanicOnUnknownConstraint(t *testing.T) {
+	// Then
+	assert.PanicsWithValue(t, "webserver: unknown param constraint 'date'", func() {
+		webserver.NewServer().Get("/{day:date}", emptyHandler)
+	})
+}
+
+func TestShouldPanicOnNilHandlerRegistration(t *testing.T) {
+	// Then
+	assert.PanicsWithValue(t, "webserver: handler must not be nil for pattern '/users'", func() {
+		webserver.NewServer().Get("/users", nil)
+	})
+
+	assert.PanicsWithValue(t, "webserver: handler must not be nil for pattern '/users'", func() {
+		webserver.NewServer().HandleE(http.MethodGet, "/users", nil)
+	})
+}
+
+func TestShouldRouteWildcardPatternByHost(t *testing.T) {
+	// Given
+	named := func(name string) webserver.Handler {
+		return func(req *webserver.Request, res *webserver.Response) {
+			res.WriteText(name + " " + req.Param("sub") + " " + req.Param("**"))
+		}
+	}
+
+	server := webserver.NewServer().
+		All("example.com"+webserver.WildcardPattern, named("host")).
+		All("{sub}.example.com"+webserver.WildcardPattern, named("subdomain")).
+		All(webserver.WildcardPattern, named("any"))
+
+	matrix := []struct {
+		url, body string
+	}{
+		{"http://example.com/", "host  "},
+		{"http://example.com/a/b", "host  a/b"},
+		{"http://example.com:8080/a", "host  a"},
+		{"http://EXAMPLE.com/a", "host  a"},
+		{"http://example.com./a", "host  a"},
+		{"http://api.example.com/a", "subdomain api a"},
+		{"http://ACME.Example.com/a", "subdomain ACME a"},
+		{"http://other.com/a", "any  a"},
+	}
+
+	for _, item := range matrix {
+		// When
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, item.url, nil))
+
+		// Then
+		assert.Equal(t, http.StatusOK, recorder.Code, item.url)
+		assert.Equal(t, item.body, recorder.Body.String(), item.url)
+	}
+}
+
+func TestShouldServeFilesOnlyWhenNoRouteMatches(t *testing.T) {
+	// Given
+	server := webserver.NewServerWithFS(http.FS(fstest.MapFS{"assets/app.js": {Data: []byte("console.log()")}}))
+	server.Get("/assets/{id:int}", func(req *webserver.Request, res *webserver.Response) { res.WriteText("asset " + req.Param("id")) })
+	server.FileServer("/assets/")
+
+	// When
+	route := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(route, httptest.NewRequest(http.MethodGet, "/assets/1", nil))
+
+	file := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(file, httptest.NewRequest(http.MethodGet, "/assets/app.js", nil))
+
+	missing := httptest.NewRecorder()
+	server.TestHandler().ServeHTTP(missing, httptest.NewRequest(http.MethodGet, "/assets/missing.js", nil))
+
+	// Then
+	assert.Equal(t, "asset 1", route.Body.String())
+	assert.Equal(t, http.StatusOK, file.Code)
+	assert.Equal(t, "console.log()", file.Body.String())
+	assert.Equal(t, http.StatusNotFound, missing.Code)
+}
+
+func TestShouldServeFilesWithoutTrailingSlashRedirect(t *testing.T) {
+	// Given
+	server := webserver.NewServerWithFS(http.FS(fstest.MapFS{"assets/app.js": {Data: []byte("console.log()")}})).
+		SetTrailingSlashRedirect(true).
+		Get("/assets/{id:int}", func(req *webserver.Request, res *webserver.Response) { res.WriteText("asset") })
+	server.FileServer("/assets/")
+	server.FileServerAt("/static", http.FS(fstest.MapFS{"app.css": {Data: []byte("body{}")}}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	// When
+	file := serve("/assets/app.js")
+	mounted := serve("/static/app.css")
+	route := serve("/assets/1")
+
+	// Then
+	assert.Equal(t, http.StatusOK, file.Code)
+	assert.Equal(t, "console.log()", file.Body.String())
+	assert.Equal(t, http.StatusOK, mounted.Code)
+	assert.Equal(t, "body{}", mounted.Body.String())
+	assert.Equal(t, http.StatusMovedPermanently, route.Code)
+	assert.Equal(t, "/assets/1/", route.Header().Get("Location"))
+}
+
+func TestShouldServeFilesFromFileSystemsMountedAtPrefixes(t *testing.T) {
+	// Given
+	server := webserver.NewServerWithFS(http.FS(fstest.MapFS{"page.html": {Data: []byte("<p>page</p>")}}))
+	server.FileServerAt("/static", http.FS(fstest.MapFS{"app.js": {Data: []byte("console.log()")}}))
+	server.FileServerAt("/uploads/", http.FS(fstest.MapFS{"avatars/1.txt": {Data: []byte("avatar")}}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	// When
+	static := serve("/static/app.js")
+	upload := serve("/uploads/avatars/1.txt")
+	crossed := serve("/static/avatars/1.txt")
+	serverFile := serve("/static/page.html")
+
+	// Then
+	assert.Equal(t, http.StatusOK, static.Code)
+	assert.Equal(t, "console.log()", static.Body.String())
+	assert.Equal(t, http.StatusOK, upload.Code)
+	assert.Equal(t, "avatar", upload.Body.String())
+	assert.Equal(t, http.StatusNotFound, crossed.Code)
+	assert.Equal(t, http.StatusNotFound, serverFile.Code)
+}
+
+func TestShouldServeFilesOnlyForHostOfFileServer(t *testing.T) {
+	// Given
+	server := webserver.NewServerWithFS(http.FS(fstest.MapFS{"page.html": {Data: []byte("<p>page</p>")}}))
+	server.FileServer("Example.com/")
+	server.FileServerAt("cdn.example.com/static", http.FS(fstest.MapFS{"app.js": {Data: []byte("console.log()")}}))
+
+	serve := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// When
+	page := serve("example.com:8080", "/page.html")
+	otherHost := serve("other.com", "/page.html")
+	static := serve("CDN.example.com", "/static/app.js")
+	staticOtherHost := serve("example.com", "/static/app.js")
+
+	// Then
+	assert.Equal(t, http.StatusOK, page.Code)
+	assert.Equal(t, "<p>page</p>", page.Body.String())
+	assert.Equal(t, http.StatusNotFound, otherHost.Code)
+	assert.Equal(t, http.StatusOK, static.Code)
+	assert.Equal(t, "console.log()", static.Body.String())
+	assert.Equal(t, http.StatusNotFound, staticOtherHost.Code)
+
+	assert.PanicsWithValue(t, "webserver: file server host must be static for pattern '{sub}.example.com/'", func() {
+		server.FileServer("{sub}.example.com/")
+	})
+}
+
+func TestShouldPanicMountingNilFileSystem(t *testing.T) {
+	// Then
+	assert.PanicsWithValue(t, "webserver: file system must not be nil for prefix '/static'", func() {
+		webserver.NewServer().FileServerAt("/static", nil)
+	})
+}
+
+func TestShouldAnswerHeadOnFileServerWithGetHeaders(t *testing.T) {
+	// Given
+	fileSystem := http.FS(fstest.MapFS{"assets/app.js": {Data: []byte("console.log()")}})
+	setup := func(server *webserver.Server) { server.FileServer("/assets/") }
+
+	get := WebServerTest{ServerFS: fileSystem, ServerSetup: setup, RequestPath: "/assets/app.js"}
+	head := WebServerTest{ServerFS: fileSystem, ServerSetup: setup, RequestMethod: http.MethodHead, RequestPath: "/assets/app.js"}
+
+	// When
+	getRes, getBody, err := get.DoAndReadBody()
+	panicIfNotNil(err)
+
+	headRes, headBody, err := head.DoAndReadBody()
+	panicIfNotNil(err)
+
+	// Then
+	assert.Equal(t, "console.log()", getBody)
+	assert.Empty(t, headBody)
+	assert.Equal(t, "13", headRes.Header.Get("Content-Length"))
+	assert.Equal(t, getRes.Header.Get("Content-Length"), headRes.Header.Get("Content-Length"))
+	assert.Equal(t, getRes.Header.Get(webserver.ContentTypeHeader), headRes.Header.Get(webserver.ContentTypeHeader))
+	assert.Equal(t, getRes.Header.Get("Last-Modified"), headRes.Header.Get("Last-Modified"))
+}
+
+func TestShouldServePrecompressedSidecarsFromFileServer(t *testing.T) {
+	// Given
+	server := webserver.NewServerWithFS(http.FS(fstest.MapFS{
+		"assets/style.css":    {Data: []byte("body{}")},
+		"assets/style.css.gz": {Data: []byte("gzip data")},
+		"assets/style.css.br": {Data: []byte("br data")},
+		"assets/app.js":       {Data: []byte("console.log()")},
+	}))
+	server.FileServer("/assets/")
+
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		recorder := httptest.NewRecorder()
+		server.TestHandler().ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// When
+	gzipped := serve("/assets/style.css", "gzip")
+	brotli := serve("/assets/style.css", "gzip, br")
+	plain := serve("/assets/style.css", "")
+	withoutSidecar := serve("/assets/app.js", "gzip, br")
+
+	// Then
+	assert.Equal(t, "gzip data", gzipped.Body.String())
+	assert.Equal(t, "gzip", gzipped.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/css; charset=utf-8", gzipped.Header().Get(webserver.ContentTypeHeader))
+	assert.Equal(t, "Accept-Encoding", gzipped.Header().Get("Vary"))
+
+	assert.Equal(t, "br data", brotli.Body.String())
+	assert.Equal(t, "br", brotli.Header().Get("Content-Encoding"))
+
+	assert.Equal(t, "body{}", plain.Body.String())
+	assert.Empty(t, plain.Header().Get("Content-Encoding"))
+
+	assert.Equal(t, "console.log()", withoutSidecar.Body.String())
+	assert.Empty(t, withoutSidecar.Header().Get("Content-Encoding"))
+}
+
+func TestShouldPreferSpecificRoutesOverWildcards(t *testing.T) {
+	// Given
+	named := func(name string) webserver.Handler {
+		return func(req *webserver.Request, res *webserver.Response) { res.WriteText(name) }
+	}
+
+	// The wildcards are registered first, the order must not matter
+	server := webserver.NewServer().SetLogOutput(io.Discard).
+		Get("/api/**", named("wildcard")).
+		Get("/api/{id}", named("param")).
+		Get("/api/{id:int}", named("int")).
+		Get("/api/{id}/edit", named("edit")).
+		Get("/api/users", named("users")).
+		Post("/api/**", named("wildcard post")).
+		Put("/api/{id}", named("param put"))
+
+	matrix := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{http.MethodGet, "/api/users", http.StatusOK, "users"},
+		{http.MethodGet, "/api/1", http.StatusOK, "int"},
+		{http.MethodGet, "/api/john", http.StatusOK, "param"},
+		{http.MethodGet, "/api/john/edit", http.StatusOK, "edit"},
+		{http.MethodGet, "/api/john/other", http.StatusOK, "wildcard"},
+		{http.MethodGet, "/api/users/1/posts", http.StatusOK, "wildcard"},
+		{http.MethodPost, "/api/users", http.StatusOK, "wildcard post"},
+		{http.MethodPost, "/api/1", http.StatusOK, "wildcard post"},
+		{http.MethodPut, "/api/1", http.StatusOK, "param put"},
+		{http.MethodPut, "/api/users", http.StatusOK, "param put"},
+		{http.MethodDelete, "/api/users", http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)},
+		{http.MethodDelete, "/api/1/edit", http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)},
+	}
+
+	for _, item := range matrix {
+		recorder := httptest.NewRecorder()
+
+		// When
+		server.TestHandler().ServeHTTP(recorder, httptest.NewRequest(item.method, item.path, nil))
+
+		// Then
+		assert.Equal(t, item.status, recorder.Code, item.method+" "+item.path)
+		assert.Equal(t, item.body, recorder.Body.String(), item.method+" "+item.path)
+	}
+}
+
+func TestShouldOrderRoutesBySpecificity(t *testing.T) {
+	// Given
+	named := func(name string) webserver.Handler {
+		return func(req *webserver.Request, res *webserver.Response) { res.WriteText(name) }
+	}
+
+	// Registered from the least specific, the order must not matter
+	server := webserver.NewServer().
+		Get("/a/{id...}", named("catch-all")).
+		Get("/a/{id?}", named("optional")).
+		Get("/a/{id}", named("param")).
+		Get("/a/{id:int}", named("int")).
+		Get("host.test/a/{id}", named("host")).
+		Get("/b/**", named("tail")).
+		Get("/b/{id...}", named("catch-all")).
+		Get("/c/{id...}", named("catch-all")).
+		Get("/c/*", named("star")).
+		Get("/d/{id}/{other}", named("params")).
+		Get("/d/{id}/b", named("static"))
+
+	matrix := []struct {
+		host, path string
+		body       string
+	}{
+		{"example.com", "/a/1", "int"},
+		{"host.test", "/a/1", "int"},
+		{"host.test", "/a/john", "host"},
+		{"example.com", "/a/john", "param"},
+		{"example.com", "/a/", "optional"},
+		{"example.com", "/a/john/doe", "catch-all"},
+		{"example.com", "/b/john/doe", "catch-all"},
+		{"example.com", "/c/john", "star"},
+		{"example.com", "/d/john/b", "static"},
+		{"example.com", "/d/john/doe", "params"},
+	}
+
+	for _, item := range matrix {
+		req := httptest.NewRequest(http.MethodGet, item.path, nil)
+		req.Host = item.host
+		recorder := httptest.NewRecorder()
+
+		// When
+		server.TestHandler().ServeHTTP(recorder, req)
+
+		// Then
+		assert.Equal(t, item.body, recorder.Body.String(), item.host+item.path)
+	}
+}
+
+func TestStaticRouteShouldAllocateLessThanDynamicRoute(t *testing.T) {
+	// Given
+	server := webserver.NewServer().
+		Get("/static1/static2", emptyHandler).
+		Get("/static1/{p1}/static2", emptyHandler)
+
+	allocs := func(path string) float64 {
+		handler, req, recorder := server.TestHandler(), httptest.NewRequest(http.MethodGet, path, nil), httptest.NewRecorder()
+		return testing.AllocsPerRun(100, func() { handler.ServeHTTP(recorder, req) })
+	}
+
+	// When
+	static, dynamic := allocs("/static1/static2/"), allocs("/static1/param1/static2")
+
+	// Then
+	assert.Less(t, static, dynamic)
+}
+
+func BenchmarkStaticRoute(b *testing.B) {
+	benchmarkRoute(b, "/static1/static2", "/static1/static2")
+}
+
+func BenchmarkDynamicRoute(b *testing.B) {
+	benchmarkRoute(b, "/static1/{p1}/static2", "/static1/param1/static2")
+}
+
+func benchmarkRoute(b *testing.B, pattern, path string) {
+	handler := webserver.NewServer().Get(pattern, emptyHandler).TestHandler()
+	req, recorder := httptest.NewRequest(http.MethodGet, path, nil), httptest.NewRecorder()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(recorder, req)
+	}
+}
+
 func panicIfNotNil(err error) {
 	if err != nil {
 		panic(err)
@@ -0,0 +1,31 @@
+package webserver
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultAccessLogFormat = "%method %path %status %latency %ip"
+
+// EnableAccessLog logs every request, replacing %method, %path, %status, %latency and %ip in the format
+func (this *Server) EnableAccessLog(format string) *Server {
+	if format == "" {
+		format = DefaultAccessLogFormat
+	}
+
+	this.accessLogFormat = format
+	return this
+}
+
+func (this *Server) logAccess(req *Request, res *Response, start time.Time) {
+	line := strings.NewReplacer(
+		"%method", req.Raw.Method,
+		"%path", req.Raw.URL.Path,
+		"%status", strconv.Itoa(res.writer.status),
+		"%latency", time.Since(start).String(),
+		"%ip", req.ClientIP(),
+	).Replace(this.accessLogFormat)
+
+	this.logger.Info(line)
+}
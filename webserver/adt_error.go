@@ -9,6 +9,7 @@ type serverError struct {
 	statusCode int
 	message    string
 	log        any
+	header     http.Header
 }
 
 func NewError(log any) *serverError {
@@ -24,10 +25,25 @@ func (this *serverError) ExposeLog() *serverError {
 	return this
 }
 
+// SetHeader is sent with the error answer, the headers set on the response before the error may be discarded
+func (this *serverError) SetHeader(key, value string) *serverError {
+	if this.header == nil {
+		this.header = http.Header{}
+	}
+
+	this.header.Set(key, value)
+	return this
+}
+
 func (this *serverError) Error() string {
 	return fmt.Sprintf("[%d] %v", this.statusCode, this.log)
 }
 
+func (this *serverError) Unwrap() error {
+	err, _ := this.log.(error)
+	return err
+}
+
 func (this *serverError) Panic() {
 	panic(this)
 }
@@ -48,6 +64,21 @@ func (this *serverError) setDefaults() *serverError {
 	return this
 }
 
+// ValidationError tells which field couldn't be converted, it's exposed to the client as 400 Bad Request
+type ValidationError struct {
+	Field    string
+	Value    string
+	Expected string
+}
+
+func (this *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value '%s' for '%s', expected %s", this.Value, this.Field, this.Expected)
+}
+
+func newValidationError(err error) *serverError {
+	return NewHTTPError(http.StatusBadRequest, err).ExposeLog()
+}
+
 func panicIfNotNil(err error) {
 	if err != nil {
 		NewError(err).Panic()
@@ -1,14 +1,37 @@
 package webserver
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
 
 type Event struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	Data any    `json:"data"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Retry int    `json:"retry"`
+	Data  any    `json:"data"`
+}
+
+// SSEConfig sets the line ending between fields and the separator written after each event
+type SSEConfig struct {
+	Separator  string
+	LineEnding string
+}
+
+var defaultSSEConfig = SSEConfig{Separator: "\n\n", LineEnding: "\n"}
+
+type EventBuilder struct {
+	response *Response
+	event    Event
 }
 
 func (this *Event) ToBytes() []byte {
+	return this.toBytes(defaultSSEConfig.LineEnding)
+}
+
+// toBytes writes the fields in the order id, event, retry and data
+func (this *Event) toBytes(lineEnding string) []byte {
 	data, err := json.Marshal(this.Data)
 
 	if err != nil {
@@ -18,14 +41,73 @@ func (this *Event) ToBytes() []byte {
 	event := ""
 
 	if this.ID != "" {
-		event += "id: " + this.ID + "\n"
+		event += "id: " + this.ID + lineEnding
+	}
+
+	event += "event: " + this.Name + lineEnding
+
+	if this.Retry > 0 {
+		event += "retry: " + strconv.Itoa(this.Retry) + lineEnding
 	}
 
-	event += "event: " + this.Name + "\ndata: "
+	// Each line of the data needs its own field, otherwise the client ends the event early
+	lines := bytes.Split(data, []byte("\n"))
+	output := []byte(event)
+
+	for i, line := range lines {
+		if i > 0 {
+			output = append(output, lineEnding...)
+		}
+
+		output = append(append(output, "data: "...), line...)
+	}
 
-	return append([]byte(event), data...)
+	return output
 }
 
 func (this *Event) ToString() string {
 	return string(this.ToBytes())
 }
+
+// SSEConfig changes how events are written, the separator defaults to two line endings
+func (this *Response) SSEConfig(config SSEConfig) *Response {
+	if config.LineEnding == "" {
+		config.LineEnding = defaultSSEConfig.LineEnding
+	}
+
+	if config.Separator == "" {
+		config.Separator = config.LineEnding + config.LineEnding
+	}
+
+	this.sse = config
+	return this
+}
+
+func (this *Response) Event() *EventBuilder {
+	return &EventBuilder{response: this}
+}
+
+func (this *EventBuilder) ID(id string) *EventBuilder {
+	this.event.ID = id
+	return this
+}
+
+func (this *EventBuilder) Name(name string) *EventBuilder {
+	this.event.Name = name
+	return this
+}
+
+// Retry sets the reconnection time, in milliseconds
+func (this *EventBuilder) Retry(milliseconds int) *EventBuilder {
+	this.event.Retry = milliseconds
+	return this
+}
+
+func (this *EventBuilder) Data(data any) *EventBuilder {
+	this.event.Data = data
+	return this
+}
+
+func (this *EventBuilder) Flush() error {
+	return this.response.FlushEvent(&this.event)
+}
@@ -0,0 +1,123 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+type Logger struct {
+	config *loggerConfig
+	fields []logField
+}
+
+type loggerConfig struct {
+	mutex  sync.Mutex
+	output io.Writer
+	format string
+}
+
+type logField struct {
+	key   string
+	value any
+}
+
+func newLogger() *Logger {
+	return &Logger{config: &loggerConfig{output: os.Stdout, format: LogFormatText}}
+}
+
+func (this *Logger) With(key string, value any) *Logger {
+	fields := make([]logField, len(this.fields), len(this.fields)+1)
+	copy(fields, this.fields)
+
+	return &Logger{config: this.config, fields: append(fields, logField{key: key, value: value})}
+}
+
+func (this *Logger) Debug(message string) {
+	this.write("DEBUG", message)
+}
+
+func (this *Logger) Info(message string) {
+	this.write("INFO", message)
+}
+
+func (this *Logger) Error(message string) {
+	this.write("ERROR", message)
+}
+
+func (this *Logger) setFormat(format string) {
+	if format != LogFormatText && format != LogFormatJSON {
+		panic("webserver: unknown log format '" + format + "'")
+	}
+
+	this.config.mutex.Lock()
+	defer this.config.mutex.Unlock()
+
+	this.config.format = format
+}
+
+func (this *Logger) setOutput(output io.Writer) {
+	this.config.mutex.Lock()
+	defer this.config.mutex.Unlock()
+
+	this.config.output = output
+}
+
+func (this *Logger) write(level, message string) {
+	now := time.Now()
+
+	this.config.mutex.Lock()
+	defer this.config.mutex.Unlock()
+
+	var line []byte
+
+	if this.config.format == LogFormatJSON {
+		line = this.formatJSON(now, level, message)
+	} else {
+		line = this.formatText(now, level, message)
+	}
+
+	this.config.output.Write(append(line, '\n'))
+}
+
+func (this *Logger) formatText(now time.Time, level, message string) []byte {
+	var line strings.Builder
+
+	line.WriteString(now.Format(dateFormat) + " - " + level + " webserver: " + message)
+
+	for _, field := range this.fields {
+		line.WriteString(fmt.Sprintf(" %s=%v", field.key, field.value))
+	}
+
+	return []byte(line.String())
+}
+
+func (this *Logger) formatJSON(now time.Time, level, message string) []byte {
+	entry := map[string]any{
+		"time":    now.Format(time.RFC3339Nano),
+		"level":   level,
+		"logger":  "webserver",
+		"message": message,
+	}
+
+	for _, field := range this.fields {
+		entry[field.key] = field.value
+	}
+
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": level, "logger": "webserver", "message": message})
+	}
+
+	return line
+}
@@ -0,0 +1,58 @@
+package webserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParamValue wraps a param for typed reads. Unlike the Request typed getters, a missing or malformed
+// value just reads as the zero value, never panicking nor setting Request.ParamError
+type ParamValue struct {
+	value string
+}
+
+// Default replaces an empty value, like 'req.Query("page").Default(1).Int()'
+func (this ParamValue) Default(value any) ParamValue {
+	if this.value == "" {
+		this.value = fmt.Sprint(value)
+	}
+
+	return this
+}
+
+func (this ParamValue) String() string {
+	return this.value
+}
+
+func (this ParamValue) Int() int {
+	value, err := strconv.Atoi(strings.TrimSpace(this.value))
+
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+func (this ParamValue) Float() float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(this.value), 64)
+
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// Bool also reads 'on', sent by the checked HTML checkboxes
+func (this ParamValue) Bool() bool {
+	value := strings.TrimSpace(this.value)
+
+	if strings.EqualFold(value, "on") {
+		return true
+	}
+
+	parsed, _ := strconv.ParseBool(value)
+	return parsed
+}
@@ -2,27 +2,47 @@ package webserver
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+const multipartMemoryLimit = 512 * 1024
+
 type Request struct {
-	Raw        *http.Request
-	response   *Response
-	params     map[string][]string
-	files      map[string][]*multipart.FileHeader
-	body       []byte
-	readParams bool
-	readBody   bool
-	isDone     bool
+	Raw         *http.Request
+	server      *Server
+	response    *Response
+	params      map[string][]string
+	files       map[string][]*multipart.FileHeader
+	query       url.Values
+	pathParams  map[string]string
+	debugParams map[string]map[string][]string
+	logger      *Logger
+	id          string
+	bucket      string
+	route       *route
+	paramError  error
+	body        []byte
+	readParams  bool
+	readBody    bool
+	skipBody    bool
+	isDone      bool
 }
 
-func newRequest(req *http.Request) *Request {
-	return &Request{Raw: req}
+func newRequest(req *http.Request, server *Server) *Request {
+	return &Request{Raw: req, server: server}
 }
 
 func (this *Request) AllHeaders() http.Header {
@@ -46,16 +66,184 @@ func (this *Request) Header(name string) string {
 	return header[0]
 }
 
+func (this *Request) Host() string {
+	return this.Raw.Host
+}
+
+func (this *Request) Method() string {
+	return this.Raw.Method
+}
+
+// Path is the decoded URL path, RawPath the escaped one that the routes are matched against
+func (this *Request) Path() string {
+	return this.Raw.URL.Path
+}
+
+func (this *Request) RawPath() string {
+	return this.Raw.URL.EscapedPath()
+}
+
+func (this *Request) Origin() string {
+	return this.Raw.Header.Get("Origin")
+}
+
+func (this *Request) Referer() string {
+	return this.Raw.Referer()
+}
+
+func (this *Request) UserAgent() string {
+	return this.Raw.UserAgent()
+}
+
+// ClientIP uses the server remote address function, by default getRemoteAddr
+func (this *Request) ClientIP() string {
+	return this.server.remoteAddrFunc(this.Raw)
+}
+
+// ID is the X-Request-Id set by the client or a proxy, otherwise a random one generated once per request
+func (this *Request) ID() string {
+	if this.id == "" {
+		this.id = strings.TrimSpace(this.Raw.Header.Get("X-Request-Id"))
+	}
+
+	if this.id == "" {
+		this.id = newRequestID()
+	}
+
+	return this.id
+}
+
+// Log is the server logger with the request ID, method, path and client IP, so the lines logged while
+// handling the request can be correlated
+func (this *Request) Log() *Logger {
+	if this.logger == nil {
+		this.logger = this.server.logger.
+			With("request_id", this.ID()).
+			With("method", this.Raw.Method).
+			With("path", this.Raw.URL.Path).
+			With("ip", this.ClientIP())
+	}
+
+	return this.logger
+}
+
+func (this *Request) Proto() string {
+	return this.Raw.Proto
+}
+
+func (this *Request) ProtoMajor() int {
+	return this.Raw.ProtoMajor
+}
+
+func (this *Request) IsHTTP2() bool {
+	return this.Raw.ProtoMajor == 2
+}
+
+// Ranges parses the Range header for a resource of the given size, nil when there's no header
+func (this *Request) Ranges(size int64) ([]Range, error) {
+	header := this.Raw.Header.Get("Range")
+
+	if header == "" {
+		return nil, nil
+	}
+
+	ranges, err := parseRange(header, size)
+
+	if err != nil {
+		return nil, NewHTTPError(http.StatusRequestedRangeNotSatisfiable, err)
+	}
+
+	return ranges, nil
+}
+
+// MatchedPattern is the pattern of the route handling the request as registered, empty when no route matched
+// (like for the fallback and the file servers)
+func (this *Request) MatchedPattern() string {
+	if this.route == nil {
+		return ""
+	}
+
+	return this.route.pattern
+}
+
+// AllowedMethods lists the methods the routes matching the path accept, as sent in the Allow header
+func (this *Request) AllowedMethods() []string {
+	return this.server.routes.allowedMethods(this.bucket, this.Raw.Host, this.Raw.URL.EscapedPath())
+}
+
+// ExpectsContinue tells if the client waits for Response.Continue before sending the body
+func (this *Request) ExpectsContinue() bool {
+	return headerHasToken(this.Raw.Header, "Expect", "100-continue")
+}
+
+func (this *Request) IsWebSocket() bool {
+	return headerHasToken(this.Raw.Header, "Connection", "upgrade") && headerHasToken(this.Raw.Header, "Upgrade", "websocket")
+}
+
+func (this *Request) WantsSSE() bool {
+	for _, accepted := range parseAccept(this.Raw.Header.Get("Accept")) {
+		if accepted.mediaType == ContentTypeEventStream {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IfMatch lists the entity tags of the If-Match header, weak ones keep the 'W/' prefix
+func (this *Request) IfMatch() []string {
+	return parseETags(strings.Join(this.Raw.Header.Values("If-Match"), ","))
+}
+
+func (this *Request) IfNoneMatch() []string {
+	return parseETags(strings.Join(this.Raw.Header.Values("If-None-Match"), ","))
+}
+
+func (this *Request) LastEventID() string {
+	return this.Raw.Header.Get("Last-Event-ID")
+}
+
 func (this *Request) AllParams() map[string][]string {
 	this.parseParams()
 	return this.params
 }
 
+// SortedParamKeys is useful to iterate over AllParams in a stable order, like in templates
+func (this *Request) SortedParamKeys() []string {
+	params := this.AllParams()
+	keys := make([]string, 0, len(params))
+
+	for key := range params {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// DebugParams groups the params by source ('query', 'form', 'multipart' and 'path', host params included),
+// showing where the values merged by Param come from. It's nil unless server.SetDebugParams(true)
+func (this *Request) DebugParams() map[string]map[string][]string {
+	this.parseParams()
+	return this.debugParams
+}
+
 func (this *Request) Params(paramName string) []string {
 	this.parseParams()
 	return this.params[paramName]
 }
 
+// FormValue is the same as Param, for those coming from net/http
+func (this *Request) FormValue(name string) string {
+	return this.Param(name)
+}
+
+// PostFormValue only reads the body params, form-urlencoded or multipart
+func (this *Request) PostFormValue(name string) string {
+	this.parseParams()
+	return this.Raw.PostForm.Get(name)
+}
+
 func (this *Request) Param(paramName string) string {
 	this.parseParams()
 
@@ -68,6 +256,31 @@ func (this *Request) Param(paramName string) string {
 	return param[0]
 }
 
+func (this *Request) RawQuery() string {
+	return this.Raw.URL.RawQuery
+}
+
+// Query reads the first query param named, a malformed query string reads as empty instead of answering 400
+func (this *Request) Query(name string) ParamValue {
+	query := this.query
+
+	if query == nil {
+		query, _ = url.ParseQuery(this.RawQuery())
+	}
+
+	return ParamValue{value: query.Get(name)}
+}
+
+func (this *Request) QueryValues() url.Values {
+	if this.query == nil {
+		query, err := url.ParseQuery(this.RawQuery())
+		panicIfNotNilUsingStatusCode(http.StatusBadRequest, err)
+		this.query = query
+	}
+
+	return this.query
+}
+
 func (this *Request) AllFiles() map[string][]*multipart.FileHeader {
 	this.parseParams()
 	return this.files
@@ -90,6 +303,52 @@ func (this *Request) File(paramName string) *multipart.FileHeader {
 	return files[0]
 }
 
+// EachPart streams the multipart body, calling fn for each part as it arrives instead of keeping the files.
+// It consumes the body, so it can't be combined with the files and body params (see NoBodyParse)
+func (this *Request) EachPart(fn func(part *multipart.Part) error) error {
+	reader, err := this.Raw.MultipartReader()
+
+	if err != nil {
+		return NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		err = fn(part)
+		part.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (this *Request) StreamFile(paramName string, dst io.Writer) (int64, error) {
+	fileHeader := this.File(paramName)
+
+	if fileHeader == nil {
+		return 0, errors.New("no file found for param '" + paramName + "'")
+	}
+
+	file, err := fileHeader.Open()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+	return io.Copy(dst, file)
+}
+
 func (this *Request) UIntParam(paramName string) uint {
 	return uint(this.IntParam(paramName))
 }
@@ -103,7 +362,9 @@ func (this *Request) IntParam(paramName string) int {
 
 	param, err := strconv.Atoi(strParam)
 
-	panicIfNotNil(err)
+	if !this.checkParamError(paramName, strParam, "int", err) {
+		return 0
+	}
 
 	return param
 }
@@ -116,7 +377,10 @@ func (this *Request) Float64Param(paramName string) float64 {
 	}
 
 	param, err := strconv.ParseFloat(strParam, 64)
-	panicIfNotNil(err)
+
+	if !this.checkParamError(paramName, strParam, "float64", err) {
+		return 0
+	}
 
 	return param
 }
@@ -129,11 +393,18 @@ func (this *Request) Float32Param(paramName string) float32 {
 	}
 
 	param, err := strconv.ParseFloat(strParam, 32)
-	panicIfNotNil(err)
+
+	if !this.checkParamError(paramName, strParam, "float32", err) {
+		return 0
+	}
 
 	return float32(param)
 }
 
+func (this *Request) ParamError() error {
+	return this.paramError
+}
+
 func (this *Request) Body() []byte {
 	if !this.readBody {
 		this.readBody = true
@@ -148,6 +419,119 @@ func (this *Request) Body() []byte {
 	return this.body
 }
 
+// TeeBody writes the body to w (e.g. a log) and keeps it available to Body and Raw.Body
+func (this *Request) TeeBody(w io.Writer) error {
+	_, err := w.Write(this.Body())
+	return err
+}
+
+// BodyReader streams the body without keeping it in memory, Body can't be read after it
+func (this *Request) BodyReader() io.Reader {
+	return this.Raw.Body
+}
+
+func (this *Request) Bind(v any) error {
+	contentType := this.mediaType()
+	decoder, ok := this.server.decoders[contentType]
+
+	if !ok {
+		return NewHTTPError(http.StatusUnsupportedMediaType, "No decoder registered for content type '"+contentType+"'")
+	}
+
+	if err := decoder(this.Body(), v); err != nil {
+		return bindError(err)
+	}
+
+	return bindError(validateStruct(v, "json", "form", "xml"))
+}
+
+// BindBody picks the decoding by the content type, whatever the registered decoders: JSON (including '+json'
+// types) is unmarshalled, form-urlencoded and multipart values are mapped into the 'form' tagged fields.
+// Other content types are answered with 415
+func (this *Request) BindBody(v any) error {
+	var err error
+
+	switch mediaType := this.mediaType(); {
+	case mediaType == ContentTypeFormUrlEncoded || mediaType == ContentTypeFormData:
+		this.parseParams()
+		err = bindValues(this.Raw.PostForm, v, "form")
+
+	case mediaType == ContentTypeJson || strings.HasSuffix(mediaType, "+json"):
+		err = decodeJSON(this.Body(), v)
+
+	default:
+		return NewHTTPError(http.StatusUnsupportedMediaType, "Unsupported content type '"+mediaType+"'")
+	}
+
+	if err != nil {
+		return bindError(err)
+	}
+
+	return bindError(validateStruct(v, "json", "form"))
+}
+
+// BindQuery maps the query into the 'query' tagged fields, a 'default' tag is used when the param is absent
+func (this *Request) BindQuery(v any) error {
+	if err := bindValues(this.QueryValues(), v, "query"); err != nil {
+		return bindError(err)
+	}
+
+	return bindError(validateStruct(v, "query"))
+}
+
+// BindPath maps the path params into the 'path' tagged fields
+func (this *Request) BindPath(v any) error {
+	values := make(map[string][]string, len(this.pathParams))
+
+	for name, value := range this.pathParams {
+		values[name] = []string{value}
+	}
+
+	if err := bindValues(values, v, "path"); err != nil {
+		return bindError(err)
+	}
+
+	return bindError(validateStruct(v, "path"))
+}
+
+func bindError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs ValidationErrors
+	var validationErr *ValidationError
+
+	if errors.As(err, &validationErrs) {
+		return newValidationError(validationErrs)
+	}
+
+	if errors.As(err, &validationErr) {
+		return newValidationError(validationErr)
+	}
+
+	return NewHTTPError(http.StatusBadRequest, err)
+}
+
+func (this *Request) Context() context.Context {
+	return this.Raw.Context()
+}
+
+// Go runs fn in a goroutine whose panic is logged instead of crashing the server, the response is not touched
+func (this *Request) Go(fn func()) {
+	logger := this.Log()
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Error(fmt.Sprintf("panic in request goroutine: %v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
 func (this *Request) IsDone() bool {
 	if this.isDone {
 		return true
@@ -162,24 +546,58 @@ func (this *Request) IsDone() bool {
 	}
 }
 
+func (this *Request) checkParamError(name, value, expected string, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	validationErr := &ValidationError{Field: name, Value: value, Expected: expected}
+
+	if this.server.paramErrorMode == ParamErrorPanic {
+		newValidationError(validationErr).Panic()
+	}
+
+	this.paramError = validationErr
+	return false
+}
+
 func (this *Request) parseParams() {
 	if this.readParams {
 		return
 	}
 
 	this.readParams = true
-
 	this.initParams()
-	this.parseQueryParams()
-	this.parseBodyParams()
+
+	for _, source := range this.server.paramPrecedence {
+		switch source {
+		case ParamSourcePath:
+			this.parsePathParams()
+		case ParamSourceQuery:
+			this.parseQueryParams()
+		case ParamSourceBody:
+			if !this.skipBody && this.hasBody() {
+				this.parseBodyParams()
+			}
+		}
+	}
 }
 
-func (this *Request) setPathParams(pathParams map[string]string) {
-	this.initParams()
+func (this *Request) hasBody() bool {
+	if this.Raw.ContentLength == 0 {
+		return false
+	}
 
-	for name, value := range pathParams {
-		this.params[name] = append(this.params[name], value)
+	switch this.Raw.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodOptions:
+		return len(this.Raw.Header[ContentTypeHeader]) > 0
 	}
+
+	return true
+}
+
+func (this *Request) setPathParams(pathParams map[string]string) {
+	this.pathParams = pathParams
 }
 
 func (this *Request) initParams() {
@@ -188,12 +606,15 @@ func (this *Request) initParams() {
 	}
 }
 
-func (this *Request) parseQueryParams() {
-	rawQuery := this.Raw.URL.RawQuery
+func (this *Request) parsePathParams() {
+	for name, value := range this.pathParams {
+		this.params[name] = append(this.params[name], value)
+		this.debugParam("path", name, value)
+	}
+}
 
-	values, err := url.ParseQuery(rawQuery)
-	panicIfNotNilUsingStatusCode(http.StatusBadRequest, err)
-	this.copyMapToParams(values)
+func (this *Request) parseQueryParams() {
+	this.copyMapToParams("query", this.QueryValues())
 }
 
 func (this *Request) parseBodyParams() {
@@ -206,6 +627,10 @@ func (this *Request) parseBodyParams() {
 	}
 }
 
+func (this *Request) mediaType() string {
+	return parseMediaType(this.Raw.Header.Get(ContentTypeHeader))
+}
+
 func (this *Request) recreateBodyReader(body []byte) {
 	if body == nil {
 		body = this.Body()
@@ -216,32 +641,95 @@ func (this *Request) recreateBodyReader(body []byte) {
 }
 
 func (this *Request) parseFormParams() {
-	body := this.Body()
-	defer this.recreateBodyReader(body)
+	values, err := url.ParseQuery(string(this.Body()))
+	panicIfNotNilUsingStatusCode(http.StatusBadRequest, err)
 
-	panicIfNotNil(this.Raw.ParseForm())
-	this.copyMapToParams(this.Raw.PostForm)
+	this.setForm(values)
+	this.copyMapToParams("form", values)
 }
 
 func (this *Request) parseMultiPartFormParams() {
-	body := this.Body()
-	defer this.recreateBodyReader(body)
+	_, params, err := mime.ParseMediaType(this.Header(ContentTypeHeader))
+	panicIfNotNilUsingStatusCode(http.StatusBadRequest, err)
+
+	if params["boundary"] == "" {
+		NewHTTPError(http.StatusBadRequest, http.ErrMissingBoundary).Panic()
+	}
 
-	panicIfNotNil(this.Raw.ParseMultipartForm(512 * 1024))
+	reader := multipart.NewReader(bytes.NewReader(this.Body()), params["boundary"])
+	form, err := reader.ReadForm(multipartMemoryLimit)
+	panicIfNotNilUsingStatusCode(http.StatusBadRequest, err)
 
-	this.copyMapToParams(this.Raw.MultipartForm.Value)
-	this.files = this.Raw.MultipartForm.File
+	this.Raw.MultipartForm = form
+	this.setForm(form.Value)
+	this.copyMapToParams("multipart", form.Value)
+	this.files = form.File
 }
 
-func (this *Request) copyMapToParams(m map[string][]string) {
-	for key, values := range m {
-		if len(this.params[key]) == 0 {
-			this.params[key] = values
-			continue
+// setForm fills Raw.PostForm and Raw.Form as ParseForm does, Form holds the body values before the query ones
+func (this *Request) setForm(body url.Values) {
+	form := make(url.Values, len(body))
+
+	for _, values := range []url.Values{body, this.QueryValues()} {
+		for key, value := range values {
+			form[key] = append(form[key], value...)
 		}
+	}
+
+	this.Raw.PostForm = body
+	this.Raw.Form = form
+}
+
+// removeFormFiles deletes the temporary files of the multipart form, net/http only does it for its own request
+func (this *Request) removeFormFiles() {
+	if this.Raw.MultipartForm != nil {
+		_ = this.Raw.MultipartForm.RemoveAll()
+	}
+}
 
+func (this *Request) copyMapToParams(source string, m map[string][]string) {
+	for key, values := range m {
 		for _, value := range values {
-			this.params[key] = append(this.params[key], value)
+			this.debugParam(source, key, value)
+		}
+
+		// Appended even to an empty key, so the source values never share the backing array
+		this.params[key] = append(this.params[key], values...)
+	}
+}
+
+func (this *Request) debugParam(source, name, value string) {
+	if !this.server.debugParams {
+		return
+	}
+
+	if this.debugParams == nil {
+		this.debugParams = make(map[string]map[string][]string)
+	}
+
+	if this.debugParams[source] == nil {
+		this.debugParams[source] = make(map[string][]string)
+	}
+
+	this.debugParams[source][name] = append(this.debugParams[source][name], value)
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// headerHasToken checks the comma separated values of the header, like 'keep-alive, Upgrade'
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
 		}
 	}
+
+	return false
 }
@@ -4,8 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 var EventStreamHeader = map[string][]string{
@@ -14,6 +20,15 @@ var EventStreamHeader = map[string][]string{
 	"Connection":      {"keep-alive"},
 }
 
+const (
+	defaultCharset     = "utf-8"
+	maxPooledBufferCap = 64 * 1024
+)
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+var tokenPrefix = []byte("${")
+
 var contentTypesByExtension = map[string]string{
 	".html": "text/html",
 }
@@ -21,13 +36,18 @@ var contentTypesByExtension = map[string]string{
 type Response struct {
 	RawWriter http.ResponseWriter
 	RawFS     http.FileSystem
+	writer    *responseWriter
 	request   *Request
 	flusher   http.Flusher
+	charset   string
+	multipart *multipart.Writer
+	sse       SSEConfig
 	views     map[string]string // TODO Implement map[string]any, use JSON serialization?
 }
 
 func newResponse(rw http.ResponseWriter, fs http.FileSystem, req *Request) *Response {
-	return &Response{RawWriter: rw, RawFS: fs, request: req}
+	writer := newResponseWriter(rw)
+	return &Response{RawWriter: writer, RawFS: fs, writer: writer, request: req, sse: defaultSSEConfig}
 }
 
 func (this *Response) Header(key, value string) *Response {
@@ -35,6 +55,26 @@ func (this *Response) Header(key, value string) *Response {
 	return this
 }
 
+func (this *Response) SetHeader(key, value string) *Response {
+	this.RawWriter.Header().Set(key, value)
+	return this
+}
+
+func (this *Response) DelHeader(key string) *Response {
+	this.RawWriter.Header().Del(key)
+	return this
+}
+
+// ContentLength avoids the chunked encoding, the body must have exactly this length or the response breaks
+func (this *Response) ContentLength(length int64) *Response {
+	return this.SetHeader("Content-Length", strconv.FormatInt(length, 10))
+}
+
+// Autodetect sets the Content-Type sniffed from the data, instead of leaving it to the first write
+func (this *Response) Autodetect(data []byte) *Response {
+	return this.SetHeader(ContentTypeHeader, http.DetectContentType(data))
+}
+
 func (this *Response) Headers(headers map[string][]string) *Response {
 	for name, values := range headers {
 		for _, value := range values {
@@ -53,22 +93,157 @@ func (this *Response) View(key string, value string) *Response {
 	return this
 }
 
+func (this *Response) Charset(name string) *Response {
+	this.charset = name
+	this.applyCharset()
+	return this
+}
+
 func (this *Response) Status(status int) *Response {
-	this.RawWriter.WriteHeader(status)
+	this.writer.setStatus(status)
+	return this
+}
+
+// Created sets 201 and the Location of the resource created, a body can still be written
+func (this *Response) Created(location string) *Response {
+	return this.SetHeader("Location", location).Status(http.StatusCreated)
+}
+
+// NoContent answers 204 right away, so nothing else can be written
+func (this *Response) NoContent() *Response {
+	this.End(http.StatusNoContent)
+	return this
+}
+
+func (this *Response) BadRequest() *Response {
+	return this.Status(http.StatusBadRequest)
+}
+
+func (this *Response) Unauthorized() *Response {
+	return this.Status(http.StatusUnauthorized)
+}
+
+func (this *Response) Forbidden() *Response {
+	return this.Status(http.StatusForbidden)
+}
+
+func (this *Response) NotFoundStatus() *Response {
+	return this.Status(http.StatusNotFound)
+}
+
+func (this *Response) InternalError() *Response {
+	return this.Status(http.StatusInternalServerError)
+}
+
+func (this *Response) Redirect(location string, status int) {
+	http.Redirect(this.RawWriter, this.request.Raw, location, status)
+}
+
+func (this *Response) RedirectKeepingQuery(location string, status int) {
+	rawQuery := this.request.Raw.URL.RawQuery
+
+	if rawQuery != "" && !strings.Contains(location, "?") {
+		location += "?" + rawQuery
+	}
+
+	this.Redirect(location, status)
+}
+
+func (this *Response) Buffer() *Response {
+	this.writer.startBuffer()
+	return this
+}
+
+func (this *Response) Discard() *Response {
+	this.writer.discardBuffer()
+	return this
+}
+
+// Bytes is the body buffered so far, nil when the response isn't buffered
+func (this *Response) Bytes() []byte {
+	if this.writer.buffer == nil {
+		return nil
+	}
+
+	return this.writer.buffer.Bytes()
+}
+
+// Replace overwrites the buffered body, the data may reference Bytes
+func (this *Response) Replace(data []byte) error {
+	if this.writer.buffer == nil {
+		return errors.New("the response is not buffered")
+	}
+
+	replaced := bytes.NewBuffer(make([]byte, 0, len(data)))
+	replaced.Write(data)
+
+	this.writer.buffer = replaced
+	this.RawWriter.Header().Del("Content-Length")
+	return nil
+}
+
+// EnableGzip compresses the body when the client accepts it, so it must be called before the first write.
+// Flush still sends the data written so far
+func (this *Response) EnableGzip() *Response {
+	this.Header("Vary", "Accept-Encoding")
+
+	if this.request.Raw.Method != http.MethodHead && acceptsGzip(this.request.Raw.Header.Get("Accept-Encoding")) {
+		this.writer.compress = true
+	}
+
 	return this
 }
 
 func (this *Response) Render(filePath string) {
-	file, err := this.RawFS.Open(filePath)
+	if err := this.RenderE(filePath); err != nil {
+		panic(err)
+	}
+}
 
-	var data []byte
-	file.Read(data)
-	file.Close()
+// RenderE returns the error instead of panicking, a missing file is a 404 error
+func (this *Response) RenderE(filePath string) error {
+	file, err := this.RawFS.Open(filePath)
 
 	// TODO Analise better what status is, based on error
+	if err != nil {
+		return NewHTTPError(http.StatusNotFound, err)
+	}
+
+	defer file.Close()
+	return this.render(file, filePath)
+}
+
+// RenderFS renders from the file system given instead of the server one
+func (this *Response) RenderFS(fsys fs.FS, filePath string) {
+	file, err := fsys.Open(filePath)
 	panicIfNotNilUsingStatusCode(http.StatusNotFound, err)
 
-	this.detectAndAddContentType(filePath).Write(this.replaceTokens(data))
+	defer file.Close()
+
+	if err := this.render(file, filePath); err != nil {
+		panic(err)
+	}
+}
+
+func (this *Response) render(file io.Reader, filePath string) error {
+	data := getBuffer()
+	defer putBuffer(data)
+
+	if _, err := data.ReadFrom(file); err != nil {
+		return NewError(err)
+	}
+
+	if len(this.views) == 0 {
+		this.detectAndAddContentType(filePath).Write(data.Bytes())
+		return nil
+	}
+
+	rendered := getBuffer()
+	defer putBuffer(rendered)
+
+	this.replaceTokens(data.Bytes(), rendered)
+	this.detectAndAddContentType(filePath).Write(rendered.Bytes())
+	return nil
 }
 
 func (this *Response) MustSupportFlusher() {
@@ -78,18 +253,25 @@ func (this *Response) MustSupportFlusher() {
 }
 
 func (this *Response) SupportFlusher() bool {
-	flusher, ok := this.RawWriter.(http.Flusher)
-
-	if !ok {
+	if _, ok := this.writer.ResponseWriter.(http.Flusher); !ok {
 		return false
 	}
 
-	this.flusher = flusher
+	this.flusher = this.writer
 	return true
 }
 
 func (this *Response) FlushEvent(event *Event) error {
-	return this.FlushText(event.ToString() + "\n\n")
+	return this.Flush(append(event.toBytes(this.sse.LineEnding), this.sse.Separator...))
+}
+
+// Chunk writes and flushes the data as soon as it's called, without any framing (chunked unless ContentLength is set)
+func (this *Response) Chunk(data []byte) error {
+	if !this.writer.written && this.request.Raw.ProtoMajor == 1 && this.RawWriter.Header().Get("Content-Length") == "" {
+		this.RawWriter.Header().Set("Transfer-Encoding", "chunked")
+	}
+
+	return this.Flush(data)
 }
 
 func (this *Response) FlushText(text string) error {
@@ -105,13 +287,59 @@ func (this *Response) Flush(data []byte) error {
 		this.MustSupportFlusher()
 	}
 
-	this.RawWriter.Write(data)
+	if _, err := this.RawWriter.Write(data); err != nil {
+		this.request.isDone = true
+		return err
+	}
+
 	this.flusher.Flush()
 	return nil
 }
 
+func (this *Response) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := this.writer.ResponseWriter.(http.Pusher)
+
+	if !ok {
+		return http.ErrNotSupported
+	}
+
+	return pusher.Push(target, opts)
+}
+
+func (this *Response) Multipart() (*multipart.Writer, error) {
+	if this.multipart != nil {
+		return this.multipart, nil
+	}
+
+	if this.writer.written {
+		return nil, errors.New("the response headers were already written")
+	}
+
+	this.multipart = multipart.NewWriter(flushWriter{this})
+	this.RawWriter.Header().Set(ContentTypeHeader, "multipart/mixed; boundary="+this.multipart.Boundary())
+
+	return this.multipart, nil
+}
+
+// Continue sends the interim '100 Continue', so a client sending 'Expect: 100-continue' uploads the body.
+// Reading the body sends it as well, this is only needed to accept before reading
+func (this *Response) Continue() {
+	if this.request.ExpectsContinue() && !this.writer.written {
+		this.writer.ResponseWriter.WriteHeader(http.StatusContinue)
+	}
+}
+
+// ExpectationFailed rejects the body announced by 'Expect: 100-continue' before the client sends it
+func (this *Response) ExpectationFailed() {
+	this.End(http.StatusExpectationFailed)
+}
+
 func (this *Response) NoBody() {
-	this.RawWriter.Write(nil)
+	this.writer.commit()
+}
+
+func (this *Response) End(status int) {
+	this.Status(status).NoBody()
 }
 
 func (this *Response) Write(data []byte) {
@@ -119,21 +347,161 @@ func (this *Response) Write(data []byte) {
 }
 
 func (this *Response) WriteJSON(value any) {
+	server := this.request.server
+	this.writeJSON(value, server.jsonIndent, server.jsonEscapeHTML)
+}
+
+func (this *Response) WriteJSONIndent(value any, indent string) {
+	this.writeJSON(value, indent, this.request.server.jsonEscapeHTML)
+}
+
+func (this *Response) WriteJSONRaw(value any) {
+	this.writeJSON(value, this.request.server.jsonIndent, false)
+}
+
+func (this *Response) writeJSON(value any, indent string, escapeHTML bool) {
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	encoder := json.NewEncoder(buffer)
+	encoder.SetIndent("", indent)
+	encoder.SetEscapeHTML(escapeHTML)
+
+	panicIfNotNil(encoder.Encode(value))
+
 	if !this.hasContentType() {
-		this.Header(ContentTypeHeader, "application/json")
+		this.Header(ContentTypeHeader, ContentTypeJson)
 	}
-	json.NewEncoder(this.RawWriter).Encode(value)
+	this.applyCharset()
+	this.Write(buffer.Bytes())
+}
+
+// JSONP wraps the JSON in a call to the callback, read from the 'callback' param when empty. The callback must
+// be an identifier path like 'jQuery.cb_1', otherwise it's a 400 error. Without callback, it's plain JSON
+func (this *Response) JSONP(callback string, value any) {
+	if callback == "" {
+		callback = this.request.Param("callback")
+	}
+
+	if callback == "" {
+		this.WriteJSON(value)
+		return
+	}
+
+	if !isJSONPCallback(callback) {
+		NewHTTPError(http.StatusBadRequest, "Invalid JSONP callback").Panic()
+	}
+
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	// The comment keeps the body from starting with bytes controlled by the client
+	buffer.WriteString("/**/" + callback + "(")
+
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(this.request.server.jsonEscapeHTML)
+	panicIfNotNil(encoder.Encode(value))
+
+	buffer.Truncate(buffer.Len() - 1)
+	buffer.WriteString(");")
+
+	this.SetHeader(ContentTypeHeader, "application/javascript").SetHeader("X-Content-Type-Options", "nosniff")
+	this.applyCharset()
+	this.Write(buffer.Bytes())
+}
+
+func (this *Response) Send(value any) {
+	server := this.request.server
+	contentType := negotiate(this.request.Raw.Header.Get("Accept"), server.encoderTypes())
+
+	if contentType == "" {
+		contentType = ContentTypeJson
+	}
+
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	panicIfNotNil(server.encoders[contentType](buffer, value))
+
+	if !this.hasContentType() {
+		this.Header(ContentTypeHeader, contentType)
+	}
+	this.applyCharset()
+
+	this.Write(buffer.Bytes())
 }
 
 func (this *Response) WriteText(text string) {
-	this.Write([]byte(text))
+	data := []byte(text)
+
+	if !this.hasContentType() {
+		this.Header(ContentTypeHeader, http.DetectContentType(data))
+	}
+	this.applyCharset()
+
+	this.Write(data)
 }
 
-func (this *Response) replaceTokens(file []byte) []byte {
-	for token, value := range this.views {
-		file = bytes.ReplaceAll(file, []byte("${"+token+"}"), []byte(value))
+func (this *Response) finish() {
+	if this.multipart != nil {
+		this.multipart.Close()
 	}
-	return file
+
+	this.writer.flushBuffer()
+	this.writer.commit()
+	this.writer.closeGzip()
+	this.request.removeFormFiles()
+}
+
+func (this *Response) replaceTokens(file []byte, output *bytes.Buffer) {
+	for {
+		begin := bytes.Index(file, tokenPrefix)
+
+		if begin == -1 {
+			break
+		}
+
+		end := bytes.IndexByte(file[begin:], '}')
+
+		if end == -1 {
+			break
+		}
+
+		end += begin
+		value, ok := this.views[string(file[begin+len(tokenPrefix):end])]
+
+		if ok {
+			output.Write(file[:begin])
+			output.WriteString(value)
+		} else {
+			output.Write(file[:end+1])
+		}
+
+		file = file[end+1:]
+	}
+
+	output.Write(file)
+}
+
+func (this *Response) applyCharset() {
+	contentType := this.RawWriter.Header().Get(ContentTypeHeader)
+	mediaType, params, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return
+	}
+
+	charset := this.charset
+
+	if charset == "" {
+		if _, ok := params["charset"]; ok || !isTextualMediaType(mediaType) {
+			return
+		}
+		charset = defaultCharset
+	}
+
+	params["charset"] = charset
+	this.RawWriter.Header().Set(ContentTypeHeader, mime.FormatMediaType(mediaType, params))
 }
 
 func (this *Response) hasContentType() bool {
@@ -156,3 +524,63 @@ func (this *Response) detectAndAddContentType(filePath string) *Response {
 
 	return this
 }
+
+type flushWriter struct {
+	response *Response
+}
+
+func (this flushWriter) Write(data []byte) (int, error) {
+	written, err := this.response.RawWriter.Write(data)
+
+	if err == nil && this.response.SupportFlusher() {
+		this.response.flusher.Flush()
+	}
+
+	return written, err
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBufferCap {
+		return
+	}
+
+	buffer.Reset()
+	bufferPool.Put(buffer)
+}
+
+func isTextualMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "/json") || strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/javascript"
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, accepted := range parseAccept(acceptEncoding) {
+		if accepted.mediaType == "gzip" || accepted.mediaType == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isJSONPCallback(callback string) bool {
+	for _, name := range strings.Split(callback, ".") {
+		if name == "" || isDigit(name[0]) {
+			return false
+		}
+
+		for i := 0; i < len(name); i++ {
+			if char := name[i]; !isLetter(char) && !isDigit(char) && char != '_' && char != '$' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,186 @@
+package webserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written bool
+
+	// buffer holds the body while buffering, header is the header snapshot to restore on discard
+	buffer *bytes.Buffer
+	header http.Header
+
+	// compress gzips the body from the commit on, when the status allows a body
+	compress bool
+	gzip     *gzip.Writer
+}
+
+func newResponseWriter(rw http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: rw}
+}
+
+func (this *responseWriter) WriteHeader(status int) {
+	if this.written {
+		return
+	}
+
+	this.status = status
+
+	if this.buffer != nil {
+		return
+	}
+
+	this.written = true
+	this.startGzip()
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *responseWriter) Write(data []byte) (int, error) {
+	if this.buffer != nil {
+		return this.buffer.Write(data)
+	}
+
+	this.detectContentType(data)
+	this.commit()
+	return this.writeBody(data)
+}
+
+func (this *responseWriter) writeBody(data []byte) (int, error) {
+	if this.gzip != nil {
+		return this.gzip.Write(data)
+	}
+
+	return this.ResponseWriter.Write(data)
+}
+
+func (this *responseWriter) Flush() {
+	this.flushBuffer()
+	this.commit()
+
+	if this.gzip != nil {
+		this.gzip.Flush()
+	}
+
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (this *responseWriter) Unwrap() http.ResponseWriter {
+	return this.ResponseWriter
+}
+
+func (this *responseWriter) setStatus(status int) {
+	if !this.written {
+		this.status = status
+	}
+}
+
+func (this *responseWriter) commit() {
+	if this.written || this.buffer != nil {
+		return
+	}
+
+	if this.status == 0 {
+		this.status = http.StatusOK
+	}
+
+	this.WriteHeader(this.status)
+}
+
+func (this *responseWriter) startBuffer() {
+	if this.buffer != nil {
+		return
+	}
+
+	this.buffer = &bytes.Buffer{}
+	this.header = this.Header().Clone()
+}
+
+func (this *responseWriter) discardBuffer() {
+	if this.buffer == nil {
+		return
+	}
+
+	this.buffer.Reset()
+
+	if this.written {
+		return
+	}
+
+	this.status = 0
+	header := this.Header()
+
+	for name := range header {
+		delete(header, name)
+	}
+
+	for name, values := range this.header {
+		header[name] = values
+	}
+}
+
+func (this *responseWriter) flushBuffer() {
+	if this.buffer == nil {
+		return
+	}
+
+	buffer := this.buffer
+	this.buffer = nil
+	this.header = nil
+
+	this.detectContentType(buffer.Bytes())
+	this.commit()
+
+	if buffer.Len() > 0 {
+		this.writeBody(buffer.Bytes())
+	}
+}
+
+// detectContentType sniffs the uncompressed data, net/http neither sniffs an encoded body nor could it
+func (this *responseWriter) detectContentType(data []byte) {
+	if !this.compress || this.written || len(data) == 0 {
+		return
+	}
+
+	header := this.Header()
+
+	if _, found := header[ContentTypeHeader]; !found {
+		header.Set(ContentTypeHeader, http.DetectContentType(data))
+	}
+}
+
+func (this *responseWriter) startGzip() {
+	header := this.Header()
+
+	if !this.compress || this.status < http.StatusOK || this.status == http.StatusNoContent ||
+		this.status == http.StatusNotModified || header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	// The length given, if any, is the uncompressed one
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", "gzip")
+
+	this.gzip = gzipPool.Get().(*gzip.Writer)
+	this.gzip.Reset(this.ResponseWriter)
+}
+
+func (this *responseWriter) closeGzip() {
+	if this.gzip == nil {
+		return
+	}
+
+	this.gzip.Close()
+	gzipPool.Put(this.gzip)
+	this.gzip = nil
+}
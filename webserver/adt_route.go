@@ -3,17 +3,22 @@ package webserver
 import (
 	"bytes"
 	"net/http"
+	"slices"
 	"strings"
 )
 
 type routesByPattern map[string][]route
 
 type route struct {
+	pattern        string
 	dynamicHost    [][]byte
 	staticPattern  string
 	dynamicPattern [][]byte
 	methods        []string
 	handler        Handler
+
+	// treatAsFallback makes a path match with other method count as not found, instead of not allowed
+	treatAsFallback bool
 }
 
 var slashSlice = []byte{'/'}
@@ -21,41 +26,100 @@ var dotSlice = []byte{'.'}
 var emptySlice = make([]byte, 0)
 var emptyMatrix = make([][]byte, 0)
 
+// emptyParams is shared by all static routes and must never be written
+var emptyParams = make(map[string]string)
+
+var catchAllSuffix = []byte("...}")
+
+const tailParam = "**"
+
+// anyMethods is advertised for the routes registered without methods
+var anyMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
 const dynamicSymbols = "{*"
 
-func (this *routesByPattern) getRoute(method, pattern, hostPort, path string) (currentRoute *route, params map[string]string) {
-	routes := (*this)[pattern]
-	errorStatus := http.StatusNotFound
+// The mux selects the most specific pattern, but routes of parent patterns (like '/a/**' for
+// '/a/b/c') may also match the path, so they are checked before giving up
+func (this *routesByPattern) getRoute(method, pattern, hostPort, path string) (currentRoute *route, params map[string]string, errorStatus int) {
+	errorStatus = http.StatusNotFound
+
+	for {
+		for _, route := range (*this)[pattern] {
+			params, status := route.matchURLAndGetParam(hostPort, path)
+
+			if !status {
+				continue
+			}
+
+			if !route.acceptsMethod(method) {
+				if !route.treatAsFallback {
+					errorStatus = http.StatusMethodNotAllowed
+				}
+
+				continue
+			}
+
+			return &route, params, 0
+		}
+
+		if pattern == "" {
+			break
+		}
+
+		pattern = parentPattern(pattern)
+	}
+
+	return nil, nil, errorStatus
+}
+
+// allowedMethods lists the methods of every route matching the path, plus OPTIONS answered automatically
+func (this *routesByPattern) allowedMethods(pattern, hostPort, path string) []string {
+	var methods []string
+
+	for {
+		for _, route := range (*this)[pattern] {
+			if _, status := route.matchURLAndGetParam(hostPort, path); !status {
+				continue
+			}
 
-	for _, route := range routes {
-		params, status := route.matchURLAndGetParam(hostPort, path)
+			routeMethods := route.methods
 
-		if !status {
-			continue
+			if routeMethods == nil {
+				routeMethods = anyMethods
+			}
+
+			for _, method := range routeMethods {
+				if !slices.Contains(methods, method) {
+					methods = append(methods, method)
+				}
+			}
 		}
 
-		if !route.acceptsMethod(method) {
-			errorStatus = http.StatusMethodNotAllowed
-			continue
+		if pattern == "" {
+			break
 		}
 
-		return &route, params
+		pattern = parentPattern(pattern)
 	}
 
-	NewHTTPError(errorStatus, nil).Panic()
+	if len(methods) > 0 && !slices.Contains(methods, http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
 
-	// Should not reach here
-	return nil, nil
+	return methods
 }
 
-func (this *routesByPattern) Add(methods []string, pattern string, handler Handler) *route {
-	route := newRoute(methods, pattern, handler)
-	(*this)[route.staticPattern] = append((*this)[route.staticPattern], *route)
-	return route
+func (this *routesByPattern) Add(route *route) {
+	routes := append((*this)[route.staticPattern], *route)
+	sortBySpecificity(routes)
+
+	(*this)[route.staticPattern] = routes
 }
 
 func newRoute(methods []string, pattern string, handler Handler) *route {
-	route := &route{}
+	route := &route{pattern: pattern}
 	route.handler = handler
 	route.methods = methods
 
@@ -71,14 +135,12 @@ func (this *route) extractAndSetPattern(pattern []byte) {
 	indexOf := bytes.IndexByte(pattern, '/')
 
 	if indexOf == -1 {
-		this.dynamicHost = bytes.Split(pattern, dotSlice)
-		reversePattern(this.dynamicHost)
+		this.setHostPattern(pattern)
 		return
 	}
 
 	if indexOf > 0 {
-		this.dynamicHost = bytes.Split(pattern[:indexOf], dotSlice)
-		reversePattern(this.dynamicHost)
+		this.setHostPattern(pattern[:indexOf])
 		pattern = pattern[indexOf:]
 	}
 
@@ -97,25 +159,41 @@ func (this *route) extractAndSetPattern(pattern []byte) {
 
 	this.staticPattern = string(trimSlashes(staticPattern))
 	this.dynamicPattern = bytes.Split(trimSlashes(dynamicPattern), slashSlice)
+	validateConstraints(this.dynamicPattern)
+}
+
+func (this *route) setHostPattern(pattern []byte) {
+	this.dynamicHost = bytes.Split(pattern, dotSlice)
+	reversePattern(this.dynamicHost)
+	validateConstraints(this.dynamicHost)
 }
 
 func (this *route) matchURLAndGetParam(hostPort, path string) (params map[string]string, status bool) {
+
+	// Static routes don't need params neither splitting
+	if len(this.dynamicHost) == 0 && len(this.dynamicPattern) == 0 {
+		return emptyParams, trimmedLength(path) == len(this.staticPattern)
+	}
+
 	params = make(map[string]string)
 
 	// Validate dynamic host
 	if len(this.dynamicHost) > 0 {
-		host, _ := splitHostPort(hostPort)
-		hostTokens := bytes.Split([]byte(host), dotSlice)
+		hostTokens := bytes.Split([]byte(normalizeHost(hostPort)), dotSlice)
 		reversePattern(hostTokens)
 
-		if !matchTokens(this.dynamicHost, hostTokens, params) {
+		// Host names are case insensitive, but the params keep the case sent
+		if !matchTokens(this.dynamicHost, hostTokens, params, true) {
 			return nil, false
 		}
+
+		// Only the path tail is captured
+		delete(params, tailParam)
 	}
 
 	// The static part of the path was already validated by 'http' library
-	if len(path) == len(this.staticPattern) && len(this.dynamicPattern) == 0 {
-		return params, true
+	if len(this.dynamicPattern) == 0 {
+		return params, trimmedLength(path) == len(this.staticPattern)
 	}
 
 	// Split dynamic part of the path by slashes
@@ -129,57 +207,70 @@ func (this *route) matchURLAndGetParam(hostPort, path string) (params map[string
 	}
 
 	// Validate dynamic path
-	return params, matchTokens(this.dynamicPattern, dynamicPath, params)
+	return params, matchTokens(this.dynamicPattern, dynamicPath, params, false)
 }
 
-func matchTokens(tokensPattern, tokens [][]byte, params map[string]string) bool {
-	tokensLength := len(tokens)
+// matchTokens compares the static names ignoring the case when foldCase is set
+func matchTokens(tokensPattern, tokens [][]byte, params map[string]string, foldCase bool) bool {
+	if len(tokensPattern) == 0 {
+		return len(tokens) == 0
+	}
+
+	key, nextKeys := tokensPattern[0], tokensPattern[1:]
+	hasToken := len(tokens) > 0
 
-	for index, key := range tokensPattern {
-		var hasToken bool = index < tokensLength
-		var tokenValue []byte
+	switch key[0] {
 
-		if hasToken {
-			tokenValue = tokens[index]
+	// case '*': ignore
+	case '*':
+		// case '**': capture all ahead, a param named '**' by the user is written later and wins
+		if len(key) > 1 && key[1] == '*' {
+			params[tailParam] = string(bytes.Join(tokens, slashSlice))
+			return true
 		}
 
-		switch key[0] {
-
-		// case '*': ignore
-		case '*':
-			// case '**': ignore all
-			if len(key) > 1 && key[1] == '*' {
-				return true
-			}
+		if !hasToken {
+			return matchTokens(nextKeys, tokens, params, foldCase)
+		}
 
-		// case '{': parse param and validate
-		case '{':
-			name, isOptional := parsePathParam(key, tokenValue)
+		return matchTokens(nextKeys, tokens[1:], params, foldCase)
 
-			if !hasToken {
-				return isOptional
-			}
+	// case '{': parse param and validate
+	case '{':
+		// case '{name...}': capture all ahead
+		if isCatchAll(key) {
+			params[string(key[1:len(key)-len(catchAllSuffix)])] = string(bytes.Join(tokens, slashSlice))
+			return true
+		}
 
-			params[string(name)] = string(tokenValue)
+		name, isOptional := parsePathParam(key)
+		name, constraint := splitParamConstraint(name)
 
-		// default: compare static names
-		default:
-			if bytes.Compare(key, tokenValue) != 0 {
-				return false
-			}
+		// Params are only written when the rest matches, so backtracking doesn't need to undo them
+		if hasToken && satisfiesConstraint(constraint, tokens[0]) && matchTokens(nextKeys, tokens[1:], params, foldCase) {
+			params[string(name)] = string(tokens[0])
+			return true
 		}
-	}
 
-	return len(tokensPattern) >= tokensLength
-}
+		// An absent optional param lets the next keys try the same token
+		return isOptional && matchTokens(nextKeys, tokens, params, foldCase)
 
-func parsePathParam(pattern, path []byte) (name []byte, isOpt bool) {
-	isOpt = isOptional(pattern)
+	// default: compare static names
+	default:
+		return hasToken && equalName(key, tokens[0], foldCase) && matchTokens(nextKeys, tokens[1:], params, foldCase)
+	}
+}
 
-	if !isOpt && len(path) == 0 {
-		return nil, isOpt
+func equalName(key, token []byte, foldCase bool) bool {
+	if foldCase {
+		return bytes.EqualFold(key, token)
 	}
 
+	return bytes.Equal(key, token)
+}
+
+func parsePathParam(pattern []byte) (name []byte, isOpt bool) {
+	isOpt = isOptional(pattern)
 	end := len(pattern) - 1
 
 	if isOpt {
@@ -189,6 +280,10 @@ func parsePathParam(pattern, path []byte) (name []byte, isOpt bool) {
 	return pattern[1:end], isOpt
 }
 
+func isCatchAll(pattern []byte) bool {
+	return bytes.HasSuffix(pattern, catchAllSuffix)
+}
+
 func isOptional(pattern []byte) bool {
 	tokenIndex := len(pattern) - 2
 
@@ -199,6 +294,16 @@ func isOptional(pattern []byte) bool {
 	return pattern[tokenIndex] == '?'
 }
 
+func parentPattern(pattern string) string {
+	index := strings.LastIndexByte(pattern, '/')
+
+	if index == -1 {
+		return ""
+	}
+
+	return pattern[:index]
+}
+
 func trimSlashes(data []byte) []byte {
 	begin, end := 0, len(data)
 
@@ -217,6 +322,25 @@ func trimSlashes(data []byte) []byte {
 	return data[begin:end]
 }
 
+// trimmedLength is the length of trimSlashes(path) without allocating
+func trimmedLength(path string) int {
+	length := len(path)
+
+	if length == 0 {
+		return 0
+	}
+
+	if path[0] == '/' {
+		length--
+	}
+
+	if len(path) > 1 && path[len(path)-1] == '/' {
+		length--
+	}
+
+	return length
+}
+
 func (this *route) acceptsMethod(method string) bool {
 	if this.methods == nil {
 		return true
@@ -241,6 +365,12 @@ func splitHostPort(hostPort string) (host, port string) {
 	return hostPort[:colon], hostPort[colon+1:]
 }
 
+// normalizeHost drops the port and the trailing dot of fully qualified names ('example.com.')
+func normalizeHost(hostPort string) string {
+	host, _ := splitHostPort(hostPort)
+	return strings.TrimSuffix(host, ".")
+}
+
 func reversePattern(pattern [][]byte) {
 	for i, j := 0, len(pattern)-1; i < j; i, j = i+1, j-1 {
 		pattern[i], pattern[j] = pattern[j], pattern[i]
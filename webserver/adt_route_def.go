@@ -0,0 +1,41 @@
+package webserver
+
+// RouteDef declares a route to be registered by Server.Register, nil Methods accept any method.
+// The first Middleware is the outermost one and Name only identifies the route in the registration errors
+type RouteDef struct {
+	Methods    []string
+	Pattern    string
+	Handler    Handler
+	Middleware []Middleware
+	Name       string
+}
+
+func (this *Server) Register(routes []RouteDef) *Server {
+	for _, def := range routes {
+		if def.Handler == nil {
+			panic("webserver: handler must not be nil for route '" + def.label() + "'")
+		}
+
+		this.MultiHandle(def.Methods, def.Pattern, def.handler())
+	}
+
+	return this
+}
+
+func (this RouteDef) handler() Handler {
+	handler := this.Handler
+
+	for i := len(this.Middleware) - 1; i >= 0; i-- {
+		handler = this.Middleware[i](handler)
+	}
+
+	return handler
+}
+
+func (this RouteDef) label() string {
+	if this.Name != "" {
+		return this.Name
+	}
+
+	return this.Pattern
+}
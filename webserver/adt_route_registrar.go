@@ -0,0 +1,22 @@
+package webserver
+
+type RouteRegistrar struct {
+	server          *Server
+	methods         []string
+	treatAsFallback bool
+}
+
+func (this *Server) Methods(methods ...string) *RouteRegistrar {
+	return &RouteRegistrar{server: this, methods: methods}
+}
+
+// TreatAsFallback answers 404 instead of 405 when the path matches but the method doesn't, for catch-all routes
+func (this *RouteRegistrar) TreatAsFallback() *RouteRegistrar {
+	this.treatAsFallback = true
+	return this
+}
+
+func (this *RouteRegistrar) Handle(pattern string, handler Handler) *RouteRegistrar {
+	this.server.addRoute(this.methods, pattern, handler, this.treatAsFallback)
+	return this
+}
@@ -0,0 +1,194 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Decoder func(body []byte, v any) error
+
+var errUnsupportedField = errors.New("unsupported field type")
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func decodeJSON(body []byte, v any) error {
+	err := json.Unmarshal(body, v)
+
+	var typeErr *json.UnmarshalTypeError
+
+	if errors.As(err, &typeErr) {
+		return &ValidationError{Field: typeErr.Field, Value: typeErr.Value, Expected: typeErr.Type.String()}
+	}
+
+	return err
+}
+
+func decodeForm(body []byte, v any) error {
+	values, err := url.ParseQuery(string(body))
+
+	if err != nil {
+		return err
+	}
+
+	return bindValues(values, v, "form")
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		mediaType, _, _ = strings.Cut(contentType, ";")
+	}
+
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func bindValues(values map[string][]string, v any, tagName string) error {
+	target := reflect.ValueOf(v)
+
+	if target.Kind() != reflect.Pointer || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return errors.New("bind target must be a non-nil pointer to struct")
+	}
+
+	target = target.Elem()
+	targetType := target.Type()
+
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Tag.Get(tagName)
+
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldValues, ok := values[name]
+
+		if !ok || len(fieldValues) == 0 {
+			defaultValue, hasDefault := field.Tag.Lookup("default")
+
+			if !hasDefault {
+				continue
+			}
+
+			fieldValues = []string{defaultValue}
+		}
+
+		if err := setField(target.Field(i), fieldValues); err != nil {
+			var validationErr *ValidationError
+
+			if errors.As(err, &validationErr) {
+				validationErr.Field = name
+				return validationErr
+			}
+
+			return fmt.Errorf("field '%s': %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() != reflect.Slice {
+		return setValue(field, values[0])
+	}
+
+	slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+
+	for i, value := range values {
+		if err := setValue(slice.Index(i), value); err != nil {
+			return err
+		}
+	}
+
+	field.Set(slice)
+	return nil
+}
+
+// setValue returns a ValidationError without the field name when the value can't be converted
+func setValue(field reflect.Value, value string) error {
+	if err := convertValue(field, value); err != nil {
+		if errors.Is(err, errUnsupportedField) {
+			return fmt.Errorf("%w %s", err, field.Type().String())
+		}
+
+		return &ValidationError{Value: value, Expected: field.Type().String()}
+	}
+
+	return nil
+}
+
+func convertValue(field reflect.Value, value string) error {
+	switch field.Type() {
+	case timeType:
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(parsed))
+		return nil
+
+	case durationType:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+
+	default:
+		return errUnsupportedField
+	}
+
+	return nil
+}
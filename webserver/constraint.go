@@ -0,0 +1,109 @@
+package webserver
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Constraints are written as '{name:constraint}', a value that doesn't satisfy it doesn't match the route
+var paramConstraints = map[string]func(value []byte) bool{
+	"int": func(value []byte) bool {
+		_, err := strconv.ParseInt(string(value), 10, 64)
+		return err == nil
+	},
+	"uint": func(value []byte) bool {
+		_, err := strconv.ParseUint(string(value), 10, 64)
+		return err == nil
+	},
+	"float": func(value []byte) bool {
+		_, err := strconv.ParseFloat(string(value), 64)
+		return err == nil
+	},
+	"alpha": func(value []byte) bool {
+		return allBytes(value, isLetter)
+	},
+	"alnum": func(value []byte) bool {
+		return allBytes(value, func(char byte) bool { return isLetter(char) || isDigit(char) })
+	},
+	"uuid": isUUID,
+}
+
+func splitParamConstraint(name []byte) (param, constraint []byte) {
+	index := bytes.IndexByte(name, ':')
+
+	if index == -1 {
+		return name, nil
+	}
+
+	return name[:index], name[index+1:]
+}
+
+func satisfiesConstraint(constraint, value []byte) bool {
+	if len(constraint) == 0 {
+		return true
+	}
+
+	return paramConstraints[string(constraint)](value)
+}
+
+func validateConstraints(tokens [][]byte) {
+	for _, token := range tokens {
+		if len(token) == 0 || token[0] != '{' || isCatchAll(token) {
+			continue
+		}
+
+		name, _ := parsePathParam(token)
+		_, constraint := splitParamConstraint(name)
+
+		if constraint == nil {
+			continue
+		}
+
+		if _, ok := paramConstraints[string(constraint)]; !ok {
+			panic("webserver: unknown param constraint '" + string(constraint) + "'")
+		}
+	}
+}
+
+func allBytes(value []byte, accept func(char byte) bool) bool {
+	if len(value) == 0 {
+		return false
+	}
+
+	for _, char := range value {
+		if !accept(char) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLetter(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+func isUUID(value []byte) bool {
+	if len(value) != 36 {
+		return false
+	}
+
+	for i, char := range value {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if char != '-' {
+				return false
+			}
+			continue
+		}
+
+		if !isDigit(char) && !((char >= 'a' && char <= 'f') || (char >= 'A' && char <= 'F')) {
+			return false
+		}
+	}
+
+	return true
+}
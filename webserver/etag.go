@@ -0,0 +1,43 @@
+package webserver
+
+import "strings"
+
+// parseETags splits an If-Match or If-None-Match header into its entity tags, kept as written ('"a"', 'W/"b"'
+// or '*'). Commas are valid inside the quotes, so the list can't just be split. Parsing stops at a malformed tag
+func parseETags(header string) []string {
+	var tags []string
+
+	for {
+		header = strings.TrimLeft(header, " \t,")
+
+		if header == "" {
+			return tags
+		}
+
+		if header[0] == '*' {
+			tags = append(tags, "*")
+			header = header[1:]
+			continue
+		}
+
+		start := 0
+
+		if strings.HasPrefix(header, "W/") {
+			start = 2
+		}
+
+		if len(header) <= start || header[start] != '"' {
+			return tags
+		}
+
+		end := strings.IndexByte(header[start+1:], '"')
+
+		if end == -1 {
+			return tags
+		}
+
+		end += start + 2
+		tags = append(tags, header[:end])
+		header = header[end:]
+	}
+}
@@ -0,0 +1,133 @@
+package webserver
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type ForwardedForMode int
+
+const (
+	// ForwardedForFirst trusts the left-most address, the one the client claims to have (the default)
+	ForwardedForFirst ForwardedForMode = iota
+
+	// ForwardedForTrustedProxies only reads the headers sent by a trusted proxy, and takes the right-most
+	// address that isn't one of them, since the client can forge everything on its left
+	ForwardedForTrustedProxies
+)
+
+// SetForwardedFor chooses how ClientIP reads X-Forwarded-For, the trusted proxies are IPs or CIDRs
+func (this *Server) SetForwardedFor(mode ForwardedForMode, trustedProxies ...string) *Server {
+	if mode == ForwardedForFirst {
+		return this.SetRemoteAddrFunc(getRemoteAddr)
+	}
+
+	prefixes := make([]netip.Prefix, len(trustedProxies))
+
+	for i, proxy := range trustedProxies {
+		prefixes[i] = parseTrustedProxy(proxy)
+	}
+
+	return this.SetRemoteAddrFunc(func(req *http.Request) string {
+		return getTrustedRemoteAddr(req, prefixes)
+	})
+}
+
+// getRemoteAddr trusts the X-Real-Ip and X-Forwarded-For headers, falling back to the connection address
+func getRemoteAddr(req *http.Request) string {
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-Ip")); ip != "" {
+		return ip
+	}
+
+	if hops := forwardedHops(req); len(hops) > 0 {
+		return hops[0]
+	}
+
+	return connectionAddr(req)
+}
+
+func getTrustedRemoteAddr(req *http.Request, trustedProxies []netip.Prefix) string {
+	remoteAddr := connectionAddr(req)
+
+	if !isTrustedProxy(remoteAddr, trustedProxies) {
+		return remoteAddr
+	}
+
+	hops := forwardedHops(req)
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !isTrustedProxy(hops[i], trustedProxies) {
+			return hops[i]
+		}
+	}
+
+	// Every hop is a proxy, so the first one is the closest to the client
+	if len(hops) > 0 {
+		return hops[0]
+	}
+
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-Ip")); ip != "" {
+		return ip
+	}
+
+	return remoteAddr
+}
+
+// forwardedHops joins every X-Forwarded-For line, from the client to the last proxy
+func forwardedHops(req *http.Request) []string {
+	var hops []string
+
+	for _, line := range req.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(line, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	return hops
+}
+
+func connectionAddr(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return ip
+}
+
+func parseTrustedProxy(proxy string) netip.Prefix {
+	if prefix, err := netip.ParsePrefix(proxy); err == nil {
+		return prefix.Masked()
+	}
+
+	addr, err := netip.ParseAddr(proxy)
+
+	if err != nil {
+		panic("webserver: invalid trusted proxy '" + proxy + "'")
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen())
+}
+
+func isTrustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
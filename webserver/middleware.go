@@ -0,0 +1,68 @@
+package webserver
+
+import "net/http"
+
+type Middleware func(next Handler) Handler
+
+func Consumes(contentTypes ...string) Middleware {
+	allowed := make([]string, len(contentTypes))
+
+	for i, contentType := range contentTypes {
+		allowed[i] = parseMediaType(contentType)
+	}
+
+	return func(next Handler) Handler {
+		return func(req *Request, res *Response) {
+			if req.hasBody() && !acceptsMediaType(allowed, req.mediaType()) {
+				NewHTTPError(http.StatusUnsupportedMediaType, "Unsupported content type '"+req.mediaType()+"'").Panic()
+			}
+
+			next(req, res)
+		}
+	}
+}
+
+// NoBodyParse keeps params from reading the body, so the handler can stream it
+func NoBodyParse(next Handler) Handler {
+	return func(req *Request, res *Response) {
+		req.skipBody = true
+		next(req, res)
+	}
+}
+
+// ExpectContinue decides whether the client announcing 'Expect: 100-continue' may send the body, the
+// requests rejected are answered with 417 Expectation Failed
+func ExpectContinue(accept func(req *Request) bool) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request, res *Response) {
+			if req.ExpectsContinue() {
+				if !accept(req) {
+					res.ExpectationFailed()
+					return
+				}
+
+				res.Continue()
+			}
+
+			next(req, res)
+		}
+	}
+}
+
+// Compress gzips the responses of the handler for the clients accepting it
+func Compress(next Handler) Handler {
+	return func(req *Request, res *Response) {
+		res.EnableGzip()
+		next(req, res)
+	}
+}
+
+func acceptsMediaType(allowed []string, mediaType string) bool {
+	for _, pattern := range allowed {
+		if matchMediaType(pattern, mediaType) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package webserver
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Encoder func(w io.Writer, v any) error
+
+type acceptedType struct {
+	mediaType string
+	quality   float64
+}
+
+func encodeJSON(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func encodeXML(w io.Writer, v any) error {
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// negotiate returns the first offer accepted by the header, in order of preference,
+// or an empty string when the client accepts anything or nothing was matched.
+func negotiate(accept string, offers []string) string {
+	for _, accepted := range parseAccept(accept) {
+		if accepted.mediaType == "*/*" {
+			return ""
+		}
+
+		for _, offer := range offers {
+			if matchMediaType(accepted.mediaType, offer) {
+				return offer
+			}
+		}
+	}
+
+	return ""
+}
+
+func parseAccept(accept string) []acceptedType {
+	var accepted []acceptedType
+
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(item, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+			if key == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+
+		if quality > 0 {
+			accepted = append(accepted, acceptedType{mediaType: mediaType, quality: quality})
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+
+	return accepted
+}
+
+func matchMediaType(pattern, mediaType string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, pattern[:len(pattern)-1])
+	}
+
+	return pattern == mediaType
+}
@@ -0,0 +1,105 @@
+package webserver
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// precompressedEncodings are the sidecar files looked for, like 'style.css.br' for 'style.css', by preference
+var precompressedEncodings = []struct {
+	encoding string
+	suffix   string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// precompressedFileServer serves the sidecar file of the encoding accepted by the client when there's one,
+// instead of the file itself
+type precompressedFileServer struct {
+	fileSystem http.FileSystem
+	fileServer http.Handler
+}
+
+func (this precompressedFileServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	name := path.Clean("/" + req.URL.Path)
+
+	if !strings.HasSuffix(req.URL.Path, "/") {
+		if file, info, encoding := openPrecompressed(this.fileSystem, name, req.Header.Get("Accept-Encoding")); file != nil {
+			defer file.Close()
+
+			setEncodingHeaders(rw.Header(), name, encoding)
+			http.ServeContent(rw, req, name, info.ModTime(), file)
+			return
+		}
+	}
+
+	this.fileServer.ServeHTTP(rw, req)
+}
+
+// openPrecompressed opens the sidecar of the best encoding accepted, nil when there's none or the content type
+// of the file can't be told by its extension (sniffing would read the compressed data). Encodings accepted with
+// the same quality are tried by the server preference
+func openPrecompressed(fileSystem http.FileSystem, name, acceptEncoding string) (http.File, os.FileInfo, string) {
+	if acceptEncoding == "" || mime.TypeByExtension(path.Ext(name)) == "" {
+		return nil, nil, ""
+	}
+
+	accepted := parseAccept(acceptEncoding)
+	candidates := make([]int, 0, len(precompressedEncodings))
+	qualities := make([]float64, len(precompressedEncodings))
+
+	for i, sidecar := range precompressedEncodings {
+		if qualities[i] = encodingQuality(accepted, sidecar.encoding); qualities[i] > 0 {
+			candidates = append(candidates, i)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return qualities[candidates[i]] > qualities[candidates[j]]
+	})
+
+	for _, i := range candidates {
+		sidecar := precompressedEncodings[i]
+		file, err := fileSystem.Open(name + sidecar.suffix)
+
+		if err != nil {
+			continue
+		}
+
+		if info, err := file.Stat(); err == nil && !info.IsDir() {
+			return file, info, sidecar.encoding
+		}
+
+		file.Close()
+	}
+
+	return nil, nil, ""
+}
+
+// encodingQuality is the quality the encoding is accepted with, an explicit entry wins over '*'
+func encodingQuality(accepted []acceptedType, encoding string) float64 {
+	wildcard := 0.0
+
+	for _, item := range accepted {
+		if item.mediaType == encoding {
+			return item.quality
+		}
+
+		if item.mediaType == "*" && wildcard == 0 {
+			wildcard = item.quality
+		}
+	}
+
+	return wildcard
+}
+
+func setEncodingHeaders(header http.Header, name, encoding string) {
+	header.Set(ContentTypeHeader, mime.TypeByExtension(path.Ext(name)))
+	header.Set("Content-Encoding", encoding)
+	header.Add("Vary", "Accept-Encoding")
+}
@@ -0,0 +1,108 @@
+package webserver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type Range struct {
+	Start  int64
+	Length int64
+}
+
+var errUnsatisfiableRange = errors.New("no range overlaps the resource")
+
+// parseRange follows RFC 9110, ranges past the end are truncated and ranges fully past it are dropped
+func parseRange(header string, size int64) ([]Range, error) {
+	units, specs, ok := strings.Cut(header, "=")
+
+	if !ok || strings.TrimSpace(units) != "bytes" {
+		return nil, errors.New("invalid range unit")
+	}
+
+	var ranges []Range
+	noOverlap := false
+
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+
+		if spec == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+
+		if !ok {
+			return nil, errors.New("invalid range '" + spec + "'")
+		}
+
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var current Range
+
+		if first == "" {
+			// suffix range, the last N bytes
+			length, err := strconv.ParseInt(last, 10, 64)
+
+			if err != nil || length < 0 {
+				return nil, errors.New("invalid range '" + spec + "'")
+			}
+
+			if length == 0 {
+				noOverlap = true
+				continue
+			}
+
+			if length > size {
+				length = size
+			}
+
+			current = Range{Start: size - length, Length: length}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+
+			if err != nil || start < 0 {
+				return nil, errors.New("invalid range '" + spec + "'")
+			}
+
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+
+			end := size - 1
+
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+
+				if err != nil || end < start {
+					return nil, errors.New("invalid range '" + spec + "'")
+				}
+
+				if end >= size {
+					end = size - 1
+				}
+			}
+
+			current = Range{Start: start, Length: end - start + 1}
+		}
+
+		ranges = append(ranges, current)
+	}
+
+	if len(ranges) == 0 && noOverlap {
+		return nil, errUnsatisfiableRange
+	}
+
+	if len(ranges) == 0 {
+		return nil, errors.New("invalid range")
+	}
+
+	return ranges, nil
+}
+
+// ContentRange is the Content-Range header value of a 206 Partial Content answer
+func (this Range) ContentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(this.Start, 10) + "-" + strconv.FormatInt(this.Start+this.Length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
@@ -0,0 +1,118 @@
+package webserver
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KeyFunc tells which bucket of the rate limiter a request takes a token from
+type KeyFunc func(req *Request) string
+
+func KeyByIP(req *Request) string {
+	return req.ClientIP()
+}
+
+// KeyByIPAndRoute gives each route its own bucket, so a noisy endpoint doesn't starve the others
+func KeyByIPAndRoute(req *Request) string {
+	return req.ClientIP() + " " + req.MatchedPattern()
+}
+
+// RateLimit allows limit requests per window for each key (KeyByIP when nil), in bursts of up to limit. The
+// others are answered with 429 Too Many Requests and Retry-After. Routes wrapped by the same middleware share it
+func RateLimit(limit int, window time.Duration, keyFn KeyFunc) Middleware {
+	return RateLimitWithClock(limit, window, keyFn, time.Now)
+}
+
+// RateLimitWithClock is RateLimit reading the time from now, so the refill can be driven by a fake clock
+func RateLimitWithClock(limit int, window time.Duration, keyFn KeyFunc, now func() time.Time) Middleware {
+	if limit <= 0 || window <= 0 {
+		panic("webserver: rate limit and window must be positive")
+	}
+
+	if keyFn == nil {
+		keyFn = KeyByIP
+	}
+
+	limiter := newRateLimiter(limit, window)
+
+	return func(next Handler) Handler {
+		return func(req *Request, res *Response) {
+			if wait, ok := limiter.allow(keyFn(req), now()); !ok {
+				NewHTTPError(http.StatusTooManyRequests, nil).
+					SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds())))).
+					Panic()
+			}
+
+			next(req, res)
+		}
+	}
+}
+
+type rateLimiter struct {
+	mutex     sync.Mutex
+	limit     float64
+	rate      float64 // tokens per nanosecond
+	window    time.Duration
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   float64(limit),
+		rate:    float64(limit) / float64(window),
+		window:  window,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (this *rateLimiter) allow(key string, now time.Time) (wait time.Duration, ok bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.sweep(now)
+
+	bucket, found := this.buckets[key]
+
+	if found {
+		this.refill(bucket, now)
+	} else {
+		bucket = &tokenBucket{tokens: this.limit, updated: now}
+		this.buckets[key] = bucket
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, true
+	}
+
+	return time.Duration((1 - bucket.tokens) / this.rate), false
+}
+
+func (this *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens = math.Min(this.limit, bucket.tokens+float64(now.Sub(bucket.updated))*this.rate)
+	bucket.updated = now
+}
+
+// sweep drops the full buckets once per window, they're the same as new ones
+func (this *rateLimiter) sweep(now time.Time) {
+	if now.Sub(this.lastSweep) < this.window {
+		return
+	}
+
+	this.lastSweep = now
+
+	for key, bucket := range this.buckets {
+		if this.refill(bucket, now); bucket.tokens >= this.limit {
+			delete(this.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package webserver
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	return errors.New("webserver: SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,80 @@
+package webserver
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheOptions is written as the Cache-Control header, the zero value only asks clients to revalidate
+type CacheOptions struct {
+	MaxAge    time.Duration
+	Public    bool
+	Immutable bool
+	NoStore   bool
+}
+
+func (this CacheOptions) String() string {
+	if this.NoStore {
+		return "no-store"
+	}
+
+	if this.MaxAge <= 0 {
+		return "no-cache"
+	}
+
+	directives := []string{"private"}
+
+	if this.Public {
+		directives[0] = "public"
+	}
+
+	directives = append(directives, "max-age="+strconv.FormatInt(int64(this.MaxAge/time.Second), 10))
+
+	if this.Immutable {
+		directives = append(directives, "immutable")
+	}
+
+	return strings.Join(directives, ", ")
+}
+
+func (this *Response) CacheControl(cache CacheOptions) *Response {
+	return this.SetHeader("Cache-Control", cache.String())
+}
+
+// SendFile serves a file of the server file system with its content type, ETag, Last-Modified and
+// Cache-Control, answering ranges and conditional requests (304 Not Modified). A '.br' or '.gz' sidecar of
+// the file is sent instead when the client accepts its encoding
+func (this *Response) SendFile(filePath string, cache CacheOptions) {
+	file, err := this.RawFS.Open(filePath)
+	panicIfNotNilUsingStatusCode(http.StatusNotFound, err)
+	defer file.Close()
+
+	info, err := file.Stat()
+	panicIfNotNil(err)
+
+	if info.IsDir() {
+		NewHTTPError(http.StatusNotFound, "'"+filePath+"' is a directory").Panic()
+	}
+
+	// The sidecar is another representation, so it has its own ETag
+	if sidecar, sidecarInfo, encoding := openPrecompressed(this.RawFS, filePath, this.request.Raw.Header.Get("Accept-Encoding")); sidecar != nil {
+		defer sidecar.Close()
+
+		setEncodingHeaders(this.RawWriter.Header(), filePath, encoding)
+		file, info = sidecar, sidecarInfo
+	}
+
+	this.SetHeader("ETag", fileETag(info))
+	this.CacheControl(cache)
+
+	http.ServeContent(this.RawWriter, this.request.Raw, path.Base(filePath), info.ModTime(), file)
+}
+
+// fileETag is weak, since it comes from the size and the modification time instead of the content
+func fileETag(info os.FileInfo) string {
+	return `W/"` + strconv.FormatInt(info.Size(), 16) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 16) + `"`
+}
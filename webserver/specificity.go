@@ -0,0 +1,77 @@
+package webserver
+
+import "sort"
+
+// Token ranks, from the most specific to the one capturing the whole tail
+const (
+	rankStatic = iota
+	rankConstrainedParam
+	rankParam
+	rankOptionalParam
+	rankAny
+	rankCatchAll
+	rankTail
+)
+
+// sortBySpecificity keeps the routes of a bucket from the most to the least specific, so '/a/{id:int}' is
+// checked before '/a/{id}' and both before '/a/**' whatever the registration order. Ties keep that order
+func sortBySpecificity(routes []route) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].moreSpecificThan(&routes[j])
+	})
+}
+
+func (this *route) moreSpecificThan(other *route) bool {
+	if compared := compareTokens(this.dynamicPattern, other.dynamicPattern); compared != 0 {
+		return compared < 0
+	}
+
+	// A route restricted to a host pattern goes before the same path for any host
+	return len(this.dynamicHost) > 0 && len(other.dynamicHost) == 0
+}
+
+// compareTokens compares the ranks token by token, a pattern that is a prefix of the other is more specific
+func compareTokens(tokens, others [][]byte) int {
+	for i := 0; i < len(tokens) && i < len(others); i++ {
+		if compared := tokenRank(tokens[i]) - tokenRank(others[i]); compared != 0 {
+			return compared
+		}
+	}
+
+	return len(tokens) - len(others)
+}
+
+func tokenRank(token []byte) int {
+	if len(token) == 0 {
+		return rankStatic
+	}
+
+	switch token[0] {
+	case '*':
+		if len(token) > 1 && token[1] == '*' {
+			return rankTail
+		}
+
+		return rankAny
+
+	case '{':
+		if isCatchAll(token) {
+			return rankCatchAll
+		}
+
+		name, isOptional := parsePathParam(token)
+
+		if isOptional {
+			return rankOptionalParam
+		}
+
+		if _, constraint := splitParamConstraint(name); len(constraint) > 0 {
+			return rankConstrainedParam
+		}
+
+		return rankParam
+
+	default:
+		return rankStatic
+	}
+}
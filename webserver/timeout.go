@@ -0,0 +1,149 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const timeoutHeader = "X-Timeout"
+
+// timeoutWriter keeps the handler output apart, so nothing reaches the client after the timeout
+type timeoutWriter struct {
+	mutex    sync.Mutex
+	header   http.Header
+	buffer   bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (this *timeoutWriter) Header() http.Header {
+	return this.header
+}
+
+func (this *timeoutWriter) WriteHeader(status int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.timedOut || this.status != 0 {
+		return
+	}
+
+	this.status = status
+}
+
+func (this *timeoutWriter) Write(data []byte) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if this.status == 0 {
+		this.status = http.StatusOK
+	}
+
+	return this.buffer.Write(data)
+}
+
+func (this *timeoutWriter) timeout() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.timedOut = true
+}
+
+// runWithTimeout answers the error given when the handler takes longer than the timeout
+func runWithTimeout(handler Handler, req *Request, res *Response, timeout time.Duration, timeoutErr *serverError) {
+	ctx, cancel := context.WithTimeout(req.Raw.Context(), timeout)
+	defer cancel()
+
+	// The handler gets its own copy, so the original request keeps answering after the timeout
+	request := *req
+	request.Raw = req.Raw.WithContext(ctx)
+
+	writer := &timeoutWriter{header: make(http.Header)}
+	response := newResponse(writer, res.RawFS, &request)
+	response.charset = res.charset
+	request.response = response
+
+	done := make(chan struct{})
+	panicked := make(chan any, 1)
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				panicked <- err
+			}
+		}()
+
+		handler(&request, response)
+		response.finish()
+		close(done)
+	}()
+
+	select {
+	case err := <-panicked:
+		request.removeFormFiles()
+		panic(err)
+
+	case <-done:
+		header := res.RawWriter.Header()
+
+		for name, values := range writer.header {
+			header[name] = values
+		}
+
+		res.Status(writer.status)
+		res.RawWriter.Write(writer.buffer.Bytes())
+
+	case <-ctx.Done():
+		writer.timeout()
+
+		// A client that went away isn't answered, neither is it a server error to log
+		if ctx.Err() == context.DeadlineExceeded && req.Raw.Context().Err() == nil {
+			timeoutErr.Panic()
+		}
+	}
+}
+
+// Timeout answers 504 Gateway Timeout with the message when the handler takes longer than the duration, like
+// http.TimeoutHandler. The handler output is buffered until it returns (its writes after the timeout are dropped),
+// so it doesn't suit streaming handlers, which can't flush
+func Timeout(duration time.Duration, message string) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request, res *Response) {
+			runWithTimeout(next, req, res, duration, NewHTTPError(http.StatusGatewayTimeout, message).ExposeLog())
+		}
+	}
+}
+
+func (this *Server) EnableClientTimeoutHeader(maxTimeout time.Duration) *Server {
+	this.maxClientTimeout = maxTimeout
+	return this
+}
+
+// clientTimeout reads the timeout in milliseconds sent by the client, capped by the server maximum
+func (this *Server) clientTimeout(req *Request) (time.Duration, bool) {
+	if this.maxClientTimeout <= 0 {
+		return 0, false
+	}
+
+	milliseconds, err := strconv.Atoi(req.Raw.Header.Get(timeoutHeader))
+
+	if err != nil || milliseconds <= 0 {
+		return 0, false
+	}
+
+	timeout := time.Duration(milliseconds) * time.Millisecond
+
+	if timeout > this.maxClientTimeout {
+		timeout = this.maxClientTimeout
+	}
+
+	return timeout, true
+}
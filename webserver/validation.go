@@ -0,0 +1,137 @@
+package webserver
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ValidationErrors lists every field that failed, errors.As also finds each ValidationError
+type ValidationErrors []*ValidationError
+
+func (this ValidationErrors) Error() string {
+	messages := make([]string, len(this))
+
+	for i, err := range this {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (this ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(this))
+
+	for i, err := range this {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// validateStruct checks the 'validate' tags (required, min, max and oneof), the field is named by the
+// first tag found in tagNames
+func validateStruct(v any, tagNames ...string) error {
+	target := reflect.ValueOf(v)
+
+	if target.Kind() != reflect.Pointer || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	target = target.Elem()
+	targetType := target.Type()
+
+	var errs ValidationErrors
+
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+		rules, ok := field.Tag.Lookup("validate")
+
+		if !ok || !field.IsExported() {
+			continue
+		}
+
+		for _, rule := range strings.Split(rules, ",") {
+			if !checkRule(target.Field(i), strings.TrimSpace(rule)) {
+				errs = append(errs, &ValidationError{
+					Field:    fieldName(field, tagNames),
+					Value:    fmt.Sprintf("%v", target.Field(i).Interface()),
+					Expected: rule,
+				})
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+func checkRule(value reflect.Value, rule string) bool {
+	name, argument, _ := strings.Cut(rule, "=")
+
+	switch name {
+	case "required":
+		return !value.IsZero()
+
+	case "min":
+		return measure(value, rule) >= parseRuleNumber(argument, rule)
+
+	case "max":
+		return measure(value, rule) <= parseRuleNumber(argument, rule)
+
+	case "oneof":
+		current := fmt.Sprintf("%v", value.Interface())
+
+		for _, option := range strings.Fields(argument) {
+			if option == current {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	panic("webserver: unknown validation rule '" + rule + "'")
+}
+
+// measure is the number itself or the length of strings, slices and maps
+func measure(value reflect.Value, rule string) float64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len())
+	}
+
+	panic("webserver: validation rule '" + rule + "' does not support " + value.Type().String())
+}
+
+func parseRuleNumber(argument, rule string) float64 {
+	number, err := strconv.ParseFloat(argument, 64)
+
+	if err != nil {
+		panic("webserver: invalid validation rule '" + rule + "'")
+	}
+
+	return number
+}
+
+func fieldName(field reflect.StructField, tagNames []string) string {
+	for _, tagName := range tagNames {
+		name, _, _ := strings.Cut(field.Tag.Get(tagName), ",")
+
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+
+	return field.Name
+}
@@ -1,9 +1,19 @@
 package webserver
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,21 +25,82 @@ const (
 	ContentTypeFormUrlEncoded = "application/x-www-form-urlencoded"
 	ContentTypeFormData       = "multipart/form-data"
 	ContentTypeJson           = "application/json"
+	ContentTypeXml            = "application/xml"
 	ContentTypeEventStream    = "text/event-stream"
 )
 
+type ParamErrorMode int
+
+const (
+	ParamErrorPanic ParamErrorMode = iota
+	ParamErrorZeroValue
+)
+
+// ParamSource is where a param comes from, the body being either form-urlencoded or multipart
+type ParamSource int
+
+const (
+	ParamSourcePath ParamSource = iota
+	ParamSourceQuery
+	ParamSourceBody
+)
+
+// defaultParamPrecedence makes the route params win, since they select the resource
+var defaultParamPrecedence = []ParamSource{ParamSourcePath, ParamSourceQuery, ParamSourceBody}
+
 type Server struct {
+	httpServer *http.Server
 	mux        *http.ServeMux
 	fileSystem http.FileSystem
 	routes     routesByPattern
+	patterns   map[string]bool
+	decoders   map[string]Decoder
+	encoders   map[string]Encoder
+	logger     *Logger
+	listenAddr atomic.Value
+
+	paramErrorMode        ParamErrorMode
+	trailingSlashRedirect bool
+	pathCleaning          bool
+	maxClientTimeout      time.Duration
+	errorHandler          ErrorHandler
+	accessLogFormat       string
+	fallback              Handler
+	exposeErrors          bool
+	noSniff               bool
+	debugParams           bool
+	paramPrecedence       []ParamSource
+	remoteAddrFunc        func(req *http.Request) string
+	fileServers           map[string]map[string]http.Handler // by host ('' for any) and bucket
+	notFoundHandlers      map[string]Handler
+	jsonIndent            string
+	jsonEscapeHTML        bool
 }
 
 type Handler func(req *Request, res *Response)
 
+// HandlerE reports failures by returning them, the NewHTTPError ones keep their status and others are answered as 500
+type HandlerE func(req *Request, res *Response) error
+
+type ErrorHandler func(req *Request, res *Response, statusCode int, err error)
+
 func NewServer() *Server {
-	server := &Server{mux: http.NewServeMux()}
+	server := &Server{mux: http.NewServeMux(), logger: newLogger(), jsonEscapeHTML: true, remoteAddrFunc: getRemoteAddr,
+		paramPrecedence: defaultParamPrecedence}
 
+	server.httpServer = &http.Server{Handler: http.HandlerFunc(server.serveHTTP)}
 	server.routes = make(routesByPattern)
+	server.patterns = make(map[string]bool)
+	server.fileServers = make(map[string]map[string]http.Handler)
+	server.notFoundHandlers = make(map[string]Handler)
+	server.decoders = map[string]Decoder{
+		ContentTypeJson:           decodeJSON,
+		ContentTypeFormUrlEncoded: decodeForm,
+	}
+	server.encoders = map[string]Encoder{
+		ContentTypeJson: encodeJSON,
+		ContentTypeXml:  encodeXML,
+	}
 	return server
 }
 
@@ -39,36 +110,243 @@ func NewServerWithFS(fileSystem http.FileSystem) *Server {
 	return router
 }
 
+// NewServerWithEmbed serves an fs.FS (like embed.FS) from the root directory, empty or "." for all of it
+func NewServerWithEmbed(fsys fs.FS, root string) *Server {
+	if root != "" && root != "." {
+		sub, err := fs.Sub(fsys, root)
+
+		if err != nil {
+			panic("webserver: invalid embed root '" + root + "': " + err.Error())
+		}
+
+		fsys = sub
+	}
+
+	return NewServerWithFS(http.FS(fsys))
+}
+
+// WildcardPattern is the pattern used by the package-level helpers, it matches every path
+const WildcardPattern = "/**"
+
 func ListenAndServe(addr string, handler Handler) error {
-	return NewServer().All("/**", handler).ListenAndServe(addr)
+	return ListenAndServeWithPattern(addr, WildcardPattern, handler)
+}
+
+func ListenAndServeWithPattern(addr, pattern string, handler Handler) error {
+	return NewServer().All(pattern, handler).ListenAndServe(addr)
 }
 
 func ListenAndServeTLS(addr, certFile, keyFile string, handler Handler) error {
-	return NewServer().All("/**", handler).ListenAndServeTLS(addr, certFile, keyFile)
+	return NewServer().All(WildcardPattern, handler).ListenAndServeTLS(addr, certFile, keyFile)
 }
 
 func Serve(l net.Listener, handler Handler) error {
-	return NewServer().All("/**", handler).Serve(l)
+	return NewServer().All(WildcardPattern, handler).Serve(l)
 }
 
 func ServeTLS(l net.Listener, handler Handler, certFile string, keyFile string) error {
-	return NewServer().All("/**", handler).ServeTLS(l, certFile, keyFile)
+	return NewServer().All(WildcardPattern, handler).ServeTLS(l, certFile, keyFile)
+}
+
+func (this *Server) TestHandler() http.Handler {
+	return this.httpServer.Handler
 }
 
 func (this *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, this.mux)
+	listener, err := this.listen(addr, ":http")
+
+	if err != nil {
+		return err
+	}
+
+	return this.Serve(listener)
+}
+
+func (this *Server) ListenAndServeReady() (addr string, stop func(), err error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	this.listenAddr.Store(listener.Addr().String())
+	go this.Serve(listener)
+
+	return listener.Addr().String(), func() { this.httpServer.Close() }, nil
 }
 
 func (this *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
-	return http.ListenAndServeTLS(addr, certFile, keyFile, this.mux)
+	listener, err := this.listen(addr, ":https")
+
+	if err != nil {
+		return err
+	}
+
+	return this.ServeTLS(listener, certFile, keyFile)
+}
+
+// The listener is created here instead of by http.Server, so its address is known by Addr
+func (this *Server) listen(addr, defaultAddr string) (net.Listener, error) {
+	this.httpServer.Addr = addr
+
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	return net.Listen("tcp", addr)
 }
 
 func (this *Server) Serve(l net.Listener) error {
-	return http.Serve(l, this.mux)
+	this.listenAddr.Store(l.Addr().String())
+	return this.httpServer.Serve(l)
+}
+
+// Addr is the address of the last listener served, with the port chosen when binding ':0'. It's empty before serving
+func (this *Server) Addr() string {
+	addr, _ := this.listenAddr.Load().(string)
+	return addr
+}
+
+// ServeListener serves an inherited listener, like one handed off by the previous process on a restart
+func (this *Server) ServeListener(l net.Listener) error {
+	return this.Serve(l)
+}
+
+// ListenReusePort binds with SO_REUSEPORT, so more than one process can listen on the same port (linux, macOS and the BSDs)
+func ListenReusePort(addr string) (net.Listener, error) {
+	config := net.ListenConfig{Control: reusePortControl}
+	return config.Listen(context.Background(), "tcp", addr)
 }
 
 func (this *Server) ServeTLS(l net.Listener, certFile string, keyFile string) error {
-	return http.ServeTLS(l, this.mux, certFile, keyFile)
+	this.listenAddr.Store(l.Addr().String())
+	return this.httpServer.ServeTLS(l, certFile, keyFile)
+}
+
+func (this *Server) RegisterDecoder(contentType string, decoder Decoder) *Server {
+	this.decoders[parseMediaType(contentType)] = decoder
+	return this
+}
+
+func (this *Server) RegisterEncoder(contentType string, encoder Encoder) *Server {
+	this.encoders[parseMediaType(contentType)] = encoder
+	return this
+}
+
+func (this *Server) Logger() *Logger {
+	return this.logger
+}
+
+func (this *Server) SetLogFormat(format string) *Server {
+	this.logger.setFormat(format)
+	return this
+}
+
+func (this *Server) SetLogOutput(output io.Writer) *Server {
+	this.logger.setOutput(output)
+	return this
+}
+
+func (this *Server) SetParamErrorMode(mode ParamErrorMode) *Server {
+	this.paramErrorMode = mode
+	return this
+}
+
+func (this *Server) SetTrailingSlashRedirect(enabled bool) *Server {
+	this.trailingSlashRedirect = enabled
+	return this
+}
+
+// SetPathCleaning decodes '%2F' and collapses repeated slashes before matching routes
+func (this *Server) SetPathCleaning(enabled bool) *Server {
+	this.pathCleaning = enabled
+	return this
+}
+
+// SetErrorHandler renders the errors instead of the default plain text message, they're still logged
+func (this *Server) SetErrorHandler(handler ErrorHandler) *Server {
+	this.errorHandler = handler
+	return this
+}
+
+// SetRemoteAddrFunc replaces how req.ClientIP() (and the access log) finds the client address
+func (this *Server) SetRemoteAddrFunc(remoteAddrFunc func(req *http.Request) string) *Server {
+	this.remoteAddrFunc = remoteAddrFunc
+	return this
+}
+
+// SetDebugParams keeps the params grouped by source for Request.DebugParams, which costs a copy of each one
+func (this *Server) SetDebugParams(enabled bool) *Server {
+	this.debugParams = enabled
+	return this
+}
+
+// SetParamPrecedence orders the values merged by Params, so Param (the first value) reads the source that
+// comes first. The sources left out keep their default order (path, query then body) after the given ones
+func (this *Server) SetParamPrecedence(order ...ParamSource) *Server {
+	precedence := make([]ParamSource, 0, len(defaultParamPrecedence))
+
+	for _, source := range slices.Concat(order, defaultParamPrecedence) {
+		if source < ParamSourcePath || source > ParamSourceBody {
+			panic(fmt.Sprintf("webserver: unknown param source %d", source))
+		}
+
+		if !slices.Contains(precedence, source) {
+			precedence = append(precedence, source)
+		}
+	}
+
+	this.paramPrecedence = precedence
+	return this
+}
+
+// SetExposeErrors answers every error with its log instead of the status text, meant for development
+func (this *Server) SetExposeErrors(enabled bool) *Server {
+	this.exposeErrors = enabled
+	return this
+}
+
+// DisableContentSniffing sends 'X-Content-Type-Options: nosniff' on every response, so browsers trust the
+// Content-Type given (see Response.Autodetect)
+func (this *Server) DisableContentSniffing() *Server {
+	this.noSniff = true
+	return this
+}
+
+func (this *Server) SetJSONIndent(indent string) *Server {
+	this.jsonIndent = indent
+	return this
+}
+
+func (this *Server) SetEscapeHTML(escapeHTML bool) *Server {
+	this.jsonEscapeHTML = escapeHTML
+	return this
+}
+
+func (this *Server) SetBaseContext(baseContext func(net.Listener) context.Context) *Server {
+	this.httpServer.BaseContext = baseContext
+	return this
+}
+
+func (this *Server) SetConnState(connState func(net.Conn, http.ConnState)) *Server {
+	this.httpServer.ConnState = connState
+	return this
+}
+
+func (this *Server) SetKeepAlivesEnabled(enabled bool) *Server {
+	this.httpServer.SetKeepAlivesEnabled(enabled)
+	return this
+}
+
+// SetErrorLog receives the errors of the connections, like failed TLS handshakes
+func (this *Server) SetErrorLog(errorLog *log.Logger) *Server {
+	this.httpServer.ErrorLog = errorLog
+	return this
+}
+
+func (this *Server) SetMaxHeaderBytes(maxHeaderBytes int) *Server {
+	this.httpServer.MaxHeaderBytes = maxHeaderBytes
+	return this
 }
 
 // ================== HANDLERS ================== //
@@ -77,54 +355,278 @@ func (this *Server) HandleAll(pattern string, webserverHandler Handler) *Server
 	return this.MultiHandle(nil, pattern, webserverHandler)
 }
 
+// Handle also accepts comma-separated methods, like "GET, POST"
 func (this *Server) Handle(method string, pattern string, handler Handler) *Server {
-	return this.MultiHandle([]string{method}, pattern, handler)
+	return this.MultiHandle(splitMethods(method), pattern, handler)
+}
+
+func splitMethods(method string) []string {
+	methods := strings.Split(method, ",")
+
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(strings.TrimSpace(method))
+	}
+
+	return methods
+}
+
+func (this *Server) HandleE(method string, pattern string, handler HandlerE) *Server {
+	if handler == nil {
+		panic("webserver: handler must not be nil for pattern '" + pattern + "'")
+	}
+
+	return this.Handle(method, pattern, handler.handler())
+}
+
+func (this HandlerE) handler() Handler {
+	return func(req *Request, res *Response) {
+		if err := this(req, res); err != nil {
+			panic(err)
+		}
+	}
 }
 
 func (this *Server) MultiHandle(methods []string, pattern string, handler Handler) *Server {
-	pattern, isNewStaticPattern := this.addRoute(methods, pattern, handler)
+	return this.addRoute(methods, pattern, handler, false)
+}
 
-	if !isNewStaticPattern {
-		return this
+func (this *Server) addRoute(methods []string, pattern string, handler Handler, treatAsFallback bool) *Server {
+	if handler == nil {
+		panic("webserver: handler must not be nil for pattern '" + pattern + "'")
 	}
 
+	route := newRoute(methods, pattern, handler)
+	route.treatAsFallback = treatAsFallback
+
+	this.routes.Add(route)
+	this.handlePattern(route.staticPattern, len(route.dynamicPattern) > 0)
+	return this
+}
+
+// Fully static patterns are matched exactly by the mux, while dynamic ones are registered as subtrees
+func (this *Server) handlePattern(pattern string, isDynamic bool) {
 	handlePattern := "/" + pattern
+	handlerFunc := this.createHandlerFunc(pattern)
+
+	if len(pattern) > 0 {
+		this.handleMux(handlePattern, handlerFunc)
+		handlePattern += "/"
+	}
 
-	handlerFunc := func(rw http.ResponseWriter, req *http.Request) {
+	if isDynamic {
+		this.handleMux(handlePattern, handlerFunc)
+	} else {
+		this.handleMux(handlePattern+"{$}", handlerFunc)
+	}
+}
+
+func (this *Server) handleMux(pattern string, handler http.Handler) {
+	if this.patterns[pattern] {
+		return
+	}
+
+	this.patterns[pattern] = true
+	this.mux.Handle(pattern, handler)
+}
 
-		request := newRequest(req)
+// serveHTTP cleans the path ahead of the mux, which would otherwise redirect the repeated slashes itself
+func (this *Server) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	if this.pathCleaning {
+		if path := cleanPath(req.URL.EscapedPath()); path != req.URL.EscapedPath() {
+			req = withPath(req, path)
+		}
+	}
+
+	this.mux.ServeHTTP(rw, req)
+}
+
+func (this *Server) createHandlerFunc(pattern string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+
+		request := newRequest(req, this)
 		response := newResponse(rw, this.fileSystem, request)
 		request.response = response
+		request.bucket = pattern
+
+		if this.noSniff {
+			rw.Header().Set("X-Content-Type-Options", "nosniff")
+		}
+
+		if this.accessLogFormat != "" {
+			defer this.logAccess(request, response, time.Now())
+		}
 
-		defer catchAllServerErrors(request, response)
+		defer response.finish()
+		defer this.catchAllServerErrors(request, response)
 
-		route, params := this.routes.getRoute(req.Method, pattern, request.Raw.Host, req.URL.EscapedPath())
+		route, params, errorStatus := this.routes.getRoute(req.Method, pattern, request.Raw.Host, req.URL.EscapedPath())
 
-		request.setPathParams(params)
-		route.handler(request, response)
+		// Only the paths of a route are redirected, the files and the unmatched paths are answered as they are
+		if route != nil && this.trailingSlashRedirect && !strings.HasSuffix(req.URL.Path, "/") {
+			response.RedirectKeepingQuery(req.URL.EscapedPath()+"/", trailingSlashRedirectStatus(req.Method))
+			return
+		}
+
+		var handler Handler
+
+		if route != nil {
+			request.route = route
+			request.setPathParams(params)
+			handler = route.handler
+		} else {
+			handler = this.unmatchedHandler(request.Raw.Host, pattern, errorStatus)
+		}
+
+		if timeout, ok := this.clientTimeout(request); ok {
+			runWithTimeout(handler, request, response, timeout, NewHTTPError(http.StatusServiceUnavailable, "Request timeout exceeded"))
+			return
+		}
+
+		handler(request, response)
+	}
+}
+
+// unmatchedHandler answers the requests no route accepted, the file servers and the fallback only run
+// when no route matched the path
+func (this *Server) unmatchedHandler(hostPort, pattern string, errorStatus int) Handler {
+	if errorStatus == http.StatusMethodNotAllowed {
+		return allowHandler
 	}
 
-	this.mux.HandleFunc(handlePattern, handlerFunc)
+	if errorStatus == http.StatusNotFound {
+		if fileServer, ok := this.findFileServer(hostPort, pattern); ok {
+			return func(req *Request, res *Response) {
+				fileServer.ServeHTTP(res.RawWriter, req.Raw)
+			}
+		}
+
+		if notFound, ok := findByAncestor(this.notFoundHandlers, pattern); ok {
+			return func(req *Request, res *Response) {
+				res.Status(http.StatusNotFound)
+				notFound(req, res)
+			}
+		}
+
+		if this.fallback != nil {
+			return this.fallback
+		}
+	}
 
-	if len(handlePattern) > 1 {
-		this.mux.HandleFunc(handlePattern+"/", handlerFunc)
+	return func(req *Request, res *Response) {
+		NewHTTPError(errorStatus, nil).Panic()
 	}
+}
+
+// allowHandler answers OPTIONS with the methods allowed, other methods are not allowed
+func allowHandler(req *Request, res *Response) {
+	res.SetHeader("Allow", strings.Join(req.AllowedMethods(), ", "))
+
+	if req.Raw.Method == http.MethodOptions {
+		res.End(http.StatusNoContent)
+		return
+	}
+
+	NewHTTPError(http.StatusMethodNotAllowed, nil).Panic()
+}
 
+// findByAncestor returns the item registered for the pattern or the closest parent of it
+func findByAncestor[T any](items map[string]T, pattern string) (item T, ok bool) {
+	for {
+		if item, ok = items[pattern]; ok {
+			return item, true
+		}
+
+		if pattern == "" {
+			return item, false
+		}
+
+		pattern = parentPattern(pattern)
+	}
+}
+
+// Fallback handles any path no route matched, instead of answering 404 Not Found
+func (this *Server) Fallback(handler Handler) *Server {
+	this.fallback = handler
+	this.handleMux("/", this.createHandlerFunc(""))
+	return this
+}
+
+// NotFoundHandlerFor answers the unmatched paths under the prefix, with status 404 unless the handler sets
+// another one. The longest prefix wins and the paths outside every prefix go to the fallback
+func (this *Server) NotFoundHandlerFor(prefix string, handler Handler) *Server {
+	bucket := string(trimSlashes([]byte(prefix)))
+
+	this.notFoundHandlers[bucket] = handler
+	this.handlePattern(bucket, true)
 	return this
 }
 
+// FileServerStrippingPrefix serves the files under the pattern, routes under the same pattern take precedence
+// and the files are only served when none of them matches the path
 func (this *Server) FileServerStrippingPrefix(pattern string, stripPrefix string) {
-	handler := http.FileServer(this.fileSystem)
+	this.mountFileServer(pattern, stripPrefix, this.fileSystem)
+}
+
+func (this *Server) FileServer(pattern string) {
+	this.FileServerStrippingPrefix(pattern, "")
+}
+
+// FileServerAt serves the files of another file system under the prefix, '/static/app.js' being read as
+// '/app.js', so assets and uploads can be mounted side by side with the server file system
+func (this *Server) FileServerAt(prefix string, fileSystem http.FileSystem) {
+	if fileSystem == nil {
+		panic("webserver: file system must not be nil for prefix '" + prefix + "'")
+	}
+
+	_, path := splitHostPattern(prefix)
+	stripPrefix := string(trimSlashes([]byte(path)))
+
+	if len(stripPrefix) > 0 {
+		stripPrefix = "/" + stripPrefix
+	}
+
+	this.mountFileServer(prefix, stripPrefix, fileSystem)
+}
+
+func (this *Server) mountFileServer(pattern, stripPrefix string, fileSystem http.FileSystem) {
+	var handler http.Handler = precompressedFileServer{fileSystem: fileSystem, fileServer: http.FileServer(fileSystem)}
 
 	if len(stripPrefix) > 0 {
 		handler = http.StripPrefix(stripPrefix, handler)
 	}
 
-	this.mux.Handle(pattern, handler)
+	host, path := splitHostPattern(pattern)
+
+	if strings.ContainsAny(host, dynamicSymbols) {
+		panic("webserver: file server host must be static for pattern '" + pattern + "'")
+	}
+
+	bucket := string(trimSlashes([]byte(path)))
+
+	if this.fileServers[host] == nil {
+		this.fileServers[host] = make(map[string]http.Handler)
+	}
+
+	this.fileServers[host][bucket] = handler
+	this.handlePattern(bucket, true)
 }
 
-func (this *Server) FileServer(pattern string) {
-	this.FileServerStrippingPrefix(pattern, "")
+// findFileServer prefers the file servers of the request host, like the mux did with host patterns
+func (this *Server) findFileServer(hostPort, pattern string) (http.Handler, bool) {
+	if fileServer, ok := findByAncestor(this.fileServers[strings.ToLower(normalizeHost(hostPort))], pattern); ok {
+		return fileServer, true
+	}
+
+	return findByAncestor(this.fileServers[""], pattern)
+}
+
+// splitHostPattern splits 'example.com/static' as routes do, the host is lowercased since it's case insensitive
+func splitHostPattern(pattern string) (host, path string) {
+	if index := strings.IndexByte(pattern, '/'); index > 0 {
+		return strings.ToLower(pattern[:index]), pattern[index:]
+	}
+
+	return "", pattern
 }
 
 // ============== SHORCUT HANDLERS =============== //
@@ -165,12 +667,58 @@ func (this *Server) WriteJSON(pattern string, filePath string) *Server {
 	return this.Get(pattern, func(req *Request, res *Response) { res.WriteJSON(filePath) })
 }
 
-func (this *Server) addRoute(methods []string, pattern string, handler Handler) (rootPattern string, isNewStaticPattern bool) {
-	route := this.routes.Add(methods, pattern, handler)
-	return route.staticPattern, len(this.routes[route.staticPattern]) == 1
+func (this *Server) encoderTypes() []string {
+	types := make([]string, 0, len(this.encoders))
+
+	for contentType := range this.encoders {
+		types = append(types, contentType)
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+func cleanPath(path string) string {
+	if !strings.Contains(path, "//") && !strings.Contains(path, "%2F") && !strings.Contains(path, "%2f") {
+		return path
+	}
+
+	path = strings.ReplaceAll(strings.ReplaceAll(path, "%2F", "/"), "%2f", "/")
+
+	var cleaned strings.Builder
+	cleaned.Grow(len(path))
+
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+
+		cleaned.WriteByte(path[i])
+	}
+
+	return cleaned.String()
 }
 
-func catchAllServerErrors(req *Request, res *Response) {
+func withPath(req *http.Request, escapedPath string) *http.Request {
+	req = req.Clone(req.Context())
+	req.URL.RawPath = escapedPath
+
+	if path, err := url.PathUnescape(escapedPath); err == nil {
+		req.URL.Path = path
+	}
+
+	return req
+}
+
+func trailingSlashRedirectStatus(method string) int {
+	if method == http.MethodGet || method == http.MethodHead {
+		return http.StatusMovedPermanently
+	}
+
+	return http.StatusPermanentRedirect
+}
+
+func (this *Server) catchAllServerErrors(req *Request, res *Response) {
 	err := recover()
 	if err == nil {
 		return
@@ -178,16 +726,42 @@ func catchAllServerErrors(req *Request, res *Response) {
 
 	var customErr *serverError
 
-	switch err.(type) {
-	case *serverError:
-		customErr = err.(*serverError)
-	default:
+	if errValue, ok := err.(error); !ok || !errors.As(errValue, &customErr) {
 		customErr = NewError(err)
 	}
 
-	if !req.IsDone() {
-		res.Status(customErr.statusCode).WriteText(customErr.message)
+	this.logger.
+		With("status", customErr.statusCode).
+		With("method", req.Raw.Method).
+		With("path", req.Raw.URL.Path).
+		Error(customErr.Error())
+
+	res.Discard()
+
+	// Once the status is sent, the error can only be logged
+	if req.IsDone() || res.writer.written {
+		return
+	}
+
+	// The headers describing the body the handler meant to send don't fit the error
+	for _, name := range []string{ContentTypeHeader, "Content-Length", "Content-Encoding", "ETag"} {
+		res.DelHeader(name)
+	}
+
+	for name, values := range customErr.header {
+		res.RawWriter.Header()[name] = values
+	}
+
+	if this.errorHandler != nil {
+		this.errorHandler(req, res, customErr.statusCode, customErr)
+		return
+	}
+
+	message := customErr.message
+
+	if this.exposeErrors {
+		message = fmt.Sprintf("%v", customErr.log)
 	}
 
-	fmt.Println(time.Now().Format(dateFormat), "- ERROR webserver:", customErr.Error())
+	res.Status(customErr.statusCode).WriteText(message)
 }